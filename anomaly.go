@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/owulveryck/min-char-rnn/codec"
+	"github.com/owulveryck/min-char-rnn/rnn"
+)
+
+// anomaly is a line whose surprise exceeds the threshold
+type anomaly struct {
+	// Line number, starting at 1
+	Line int
+	// Offset of the line in the input, in bytes
+	Offset int64
+	// Surprise is the average negative log-probability of the elements of the line
+	Surprise float64
+	// Spans are the windows of the line that exceeds the threshold
+	Spans []span
+}
+
+// span of elements [Start, End) of a line; the offsets are expressed in elements of the codec
+// (runes for the char codec)
+type span struct {
+	Start    int
+	End      int
+	Surprise float64
+}
+
+// detectAnomalies reads r line by line and writes a JSON record to w for every line
+// whose average surprise or whose surprise averaged over a sliding window of
+// window elements exceeds the threshold.
+// Each line is conditioned on a newline, as it is when it is read in the training corpus
+func detectAnomalies(cdc codec.Codec, nn *rnn.RNN, r io.Reader, w io.Writer, threshold float64, window int) error {
+	rdr := bufio.NewReader(r)
+	enc := json.NewEncoder(w)
+	var offset int64
+	for n := 1; ; n++ {
+		line, err := rdr.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		text := strings.TrimSuffix(line, "\n")
+		if text != "" {
+			ev := codec.Evaluate(cdc, nn, strings.NewReader("\n"), strings.NewReader(text))
+			a := anomaly{
+				Line:     n,
+				Offset:   offset,
+				Surprise: -ev.LogLikelihood / float64(len(ev.LogProbs)),
+				Spans:    surprisingSpans(ev.LogProbs, threshold, window),
+			}
+			if a.Surprise > threshold || len(a.Spans) > 0 {
+				if err := enc.Encode(a); err != nil {
+					return err
+				}
+			}
+		}
+		offset += int64(len(line))
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// surprisingSpans slides a window over the log-probabilities and returns the
+// spans where the average surprise exceeds the threshold; overlapping windows are merged
+func surprisingSpans(logProbs []float64, threshold float64, window int) []span {
+	if window <= 0 || len(logProbs) < window {
+		return nil
+	}
+	var spans []span
+	s := float64(0)
+	for i := 0; i < len(logProbs); i++ {
+		s -= logProbs[i]
+		if i >= window {
+			s += logProbs[i-window]
+		}
+		if i < window-1 || s/float64(window) <= threshold {
+			continue
+		}
+		start := i - window + 1
+		if l := len(spans) - 1; l >= 0 && spans[l].End >= start {
+			spans[l].End = i + 1
+		} else {
+			spans = append(spans, span{Start: start, End: i + 1})
+		}
+	}
+	for i := range spans {
+		total := float64(0)
+		for _, l := range logProbs[spans[i].Start:spans[i].End] {
+			total -= l
+		}
+		spans[i].Surprise = total / float64(spans[i].End-spans[i].Start)
+	}
+	return spans
+}
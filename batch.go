@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/owulveryck/min-char-rnn/codec"
+	"github.com/owulveryck/min-char-rnn/rnn"
+)
+
+// params of a generation
+type params struct {
+	// Size is the number of elements to generate (default MIN_CHAR_SAMPLESIZE, not negative)
+	Size int
+	// Temperature applied to the distributions (default 1, must be positive)
+	Temperature float64
+	// Choice is hard or soft (default soft)
+	Choice string
+}
+
+// job is a line of the batch file, it describes Samples generations
+// seeded with Seed, Seed+1, ...
+type job struct {
+	// Prime is the text that primes the network (default "\n"); it must encode to one element at least
+	Prime string
+	params
+	Seed int64
+	// Samples is the number of generations (default 1, must be positive)
+	Samples int
+}
+
+// generated is the JSON record written for every sample
+type generated struct {
+	Prime         string
+	Params        params
+	Seed          int64
+	Text          string
+	LogLikelihood float64
+}
+
+type task struct {
+	id    int
+	prime string
+	// xs is the encoded prime, shared by the samples of a job
+	xs   [][]float64
+	p    params
+	seed int64
+}
+
+type result struct {
+	id int
+	g  generated
+}
+
+// generateBatch reads the jobs as JSON lines from r, runs the generations
+// on workers go-routines sharing the model, and writes one JSON record per sample
+// to w, in the order of the jobs.
+// The model is only read; a sample is fully determined by its prime, its params and its seed
+func generateBatch(cdc codec.Codec, nn *rnn.RNN, r io.Reader, w io.Writer, workers int) error {
+	filters, err := sampleFilters(cdc)
+	if err != nil {
+		return err
+	}
+	var tasks []task
+	// n is the number of the job
+	n := 0
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		j := job{
+			Prime: "\n",
+			params: params{
+				Size:        conf.SampleSize,
+				Temperature: 1,
+				Choice:      "soft",
+			},
+			Samples: 1,
+		}
+		err := dec.Decode(&j)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		n++
+		xs, err := j.check(cdc)
+		if err != nil {
+			return fmt.Errorf("job %v: %v", n, err)
+		}
+		for i := 0; i < j.Samples; i++ {
+			tasks = append(tasks, task{len(tasks), j.Prime, xs, j.params, j.Seed + int64(i)})
+		}
+	}
+
+	todo := make(chan task)
+	done := make(chan result, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range todo {
+				done <- result{t.id, generate(cdc, nn, t, filters)}
+			}
+		}()
+	}
+	go func() {
+		for _, t := range tasks {
+			todo <- t
+		}
+		close(todo)
+		wg.Wait()
+		close(done)
+	}()
+
+	// Write the records in the order of the tasks
+	enc := json.NewEncoder(w)
+	pending := make(map[int]generated)
+	next := 0
+	for res := range done {
+		pending[res.id] = res.g
+		for {
+			g, ok := pending[next]
+			if !ok {
+				break
+			}
+			if err := enc.Encode(g); err != nil {
+				return err
+			}
+			delete(pending, next)
+			next++
+		}
+	}
+	return nil
+}
+
+// check validates the job and returns its encoded prime
+func (j job) check(cdc codec.Codec) ([][]float64, error) {
+	if j.Size < 0 {
+		return nil, fmt.Errorf("The size must not be negative, got %v", j.Size)
+	}
+	if j.Temperature <= 0 {
+		return nil, fmt.Errorf("The temperature must be positive, got %v", j.Temperature)
+	}
+	if j.Samples < 1 {
+		return nil, fmt.Errorf("The number of samples must be positive, got %v", j.Samples)
+	}
+	xs := cdc.Encode(strings.NewReader(j.Prime))
+	if len(xs) == 0 {
+		return nil, fmt.Errorf("The prime %q does not encode to any element", j.Prime)
+	}
+	return xs, nil
+}
+
+func generate(cdc codec.Codec, nn *rnn.RNN, t task, filters []rnn.Filter) generated {
+	xs := t.xs
+	if t.p.Temperature != 1 {
+		filters = append([]rnn.Filter{rnn.Temperature(t.p.Temperature)}, filters...)
+	}
+	ys := nn.Predict(xs, t.p.Size, codec.Sample(t.p.Choice, rand.New(rand.NewSource(t.seed))), filters...)
+	text, _ := ioutil.ReadAll(cdc.Decode(ys))
+	return generated{
+		Prime:         t.prime,
+		Params:        t.p,
+		Seed:          t.seed,
+		Text:          string(text),
+		LogLikelihood: nn.Evaluate(xs, ys).LogLikelihood,
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	bytescodec "github.com/owulveryck/min-char-rnn/codec/bytes"
+	"github.com/owulveryck/min-char-rnn/rnn"
+)
+
+// newBytes returns a bytes codec and an untrained network
+func newBytes(t *testing.T) (*bytescodec.Bytes, *rnn.RNN) {
+	cdc, err := bytescodec.New(bytescodec.Options{BatchSize: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The flags are only defined by main
+	allowed = new(string)
+	return cdc, cdc.NewRNN()
+}
+
+func TestGenerateBatch(t *testing.T) {
+	cdc, nn := newBytes(t)
+	var out bytes.Buffer
+	err := generateBatch(cdc, nn, strings.NewReader(`{"Prime": "ab", "Size": 3, "Seed": 7, "Samples": 2}`), &out, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&out)
+	for _, seed := range []int64{7, 8} {
+		var g generated
+		if err := dec.Decode(&g); err != nil {
+			t.Fatal(err)
+		}
+		if g.Seed != seed || g.Prime != "ab" || g.Params.Size != 3 {
+			t.Fatalf("bad record %+v", g)
+		}
+	}
+	if dec.More() {
+		t.Fatal("expected a record per sample")
+	}
+}
+
+func TestGenerateBatchErrors(t *testing.T) {
+	cdc, nn := newBytes(t)
+	for _, c := range []struct {
+		job, err string
+	}{
+		{`{"Size": -1}`, "job 2: The size must not be negative, got -1"},
+		{`{"Temperature": -0.5}`, "job 2: The temperature must be positive, got -0.5"},
+		{`{"Samples": 0}`, "job 2: The number of samples must be positive, got 0"},
+		{`{"Samples": -3}`, "job 2: The number of samples must be positive, got -3"},
+		{`{"Prime": ""}`, `job 2: The prime "" does not encode to any element`},
+	} {
+		var out bytes.Buffer
+		err := generateBatch(cdc, nn, strings.NewReader(`{}`+"\n"+c.job), &out, 1)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%v: expected the error %q, got %v", c.job, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package bpe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"unicode/utf8"
+
+	"github.com/owulveryck/min-char-rnn/codec"
+	"github.com/owulveryck/min-char-rnn/codec/source"
+	"github.com/owulveryck/min-char-rnn/rnn"
+)
+
+// Options of the BPE codec
+type Options struct {
+	// Sources are the parts of the training corpus, read in sequence at every epoch.
+	// The vocabulary is built from a first reading: the non-seekable readers are always cached
+	Sources []source.Source
+	// VocabSize is the targeted number of symbols, it must be at least 256
+	VocabSize int
+	// BatchSize is the length of the sequences of the training sets
+	BatchSize int
+	// Epoch is the number of times the training corpus is read
+	Epoch int
+	codec.Windowing
+	codec.Sampling
+}
+
+// BPE is a byte-pair encoding codec.
+// Its vocabulary starts with the 256 bytes and grows with the merges
+// of the most frequent pairs of symbols learned from the training corpus
+type BPE struct {
+	loss       float64
+	smoothLoss float64
+	merges     []pair
+	ranks      map[pair]int
+	symbols    [][]byte
+	opts       Options
+	corpus     *source.Corpus
+}
+
+func init() {
+	gob.Register(&BPE{})
+	codec.Register("bpe", func() codec.Codec {
+		return &BPE{}
+	})
+}
+
+// New creates a BPE codec whose merges are learned from the training corpus
+func New(opts Options) (*BPE, error) {
+	if opts.VocabSize < alphabetSize {
+		return nil, errors.New("VocabSize must be at least 256")
+	}
+	if len(opts.Sources) == 0 {
+		return nil, errors.New("The merges are learned from the Sources")
+	}
+	b := &BPE{}
+	err := b.SetOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	var merges []pair
+	err = b.readCorpus(func(r io.Reader) error {
+		var err error
+		merges, err = learnMerges(r, opts.VocabSize)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.setMerges(merges)
+	b.smoothLoss = -math.Log(float64(1)/float64(len(b.symbols))) * float64(opts.BatchSize)
+	return b, nil
+}
+
+// SetOptions replaces the options of the codec; the merges are left untouched.
+// It is used to configure a restored codec for a new training
+func (b *BPE) SetOptions(opts Options) error {
+	if opts.BatchSize <= 0 {
+		return errors.New("BatchSize must be positive")
+	}
+	sources := make([]source.Source, len(opts.Sources))
+	for i, s := range opts.Sources {
+		s.Cache = true
+		sources[i] = s
+	}
+	corpus, err := source.NewCorpus(sources...)
+	if err != nil {
+		return err
+	}
+	b.opts = opts
+	b.corpus = corpus
+	return nil
+}
+
+// SetSampling replaces the options of the generation
+func (b *BPE) SetSampling(s codec.Sampling) {
+	b.opts.Sampling = s
+}
+
+// readCorpus calls f with the content of the corpus for the next epoch
+func (b *BPE) readCorpus(f func(io.Reader) error) error {
+	r, err := b.corpus.Next()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return f(r)
+}
+
+func (b *BPE) setMerges(merges []pair) {
+	b.merges = merges
+	b.ranks = make(map[pair]int, len(merges))
+	b.symbols = make([][]byte, alphabetSize, alphabetSize+len(merges))
+	for i := range b.symbols {
+		b.symbols[i] = []byte{byte(i)}
+	}
+	for i, p := range merges {
+		b.ranks[p] = i
+		symbol := append(append([]byte{}, b.symbols[p[0]]...), b.symbols[p[1]]...)
+		b.symbols = append(b.symbols, symbol)
+	}
+}
+
+// oneOfK returns the 1-of-K encoded vector of the symbol
+func (b *BPE) oneOfK(symbol int) []float64 {
+	oneOfK := make([]float64, len(b.symbols))
+	oneOfK[symbol] = 1
+	return oneOfK
+}
+
+// Decode an array of inputs and returns an io.Reader on the raw bytes
+// the input is an array of 1-of-K encoded vectors
+func (b *BPE) Decode(xs [][]float64) io.Reader {
+	var output bytes.Buffer
+	for _, x := range xs {
+		idx := 0
+		for idx = range x {
+			if x[idx] == 1 {
+				break
+			}
+		}
+		output.Write(b.symbols[idx])
+	}
+	return &output
+}
+
+// Encode the io.Reader into an slice composed of
+// 1-of-K encoded vectors
+func (b *BPE) Encode(r io.Reader) [][]float64 {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanChunks)
+	var xs [][]float64
+	for scanner.Scan() {
+		for _, symbol := range encode(scanner.Bytes(), b.merges, b.ranks) {
+			xs = append(xs, b.oneOfK(symbol))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return xs
+}
+
+// cacheSize is the maximum number of chunks whose encoding is kept by Feed
+const cacheSize = 1 << 16
+
+// Feed returns a channel that will be filled with TrainingSets
+// its triggers a go-routine that reads the inputs and
+// that is putting some data in the channel
+func (b *BPE) Feed() <-chan rnn.TrainingSet {
+	// The chunks are encoded once for all the epochs, up to cacheSize distinct chunks
+	cache := make(map[string][]int)
+	return b.opts.Windowing.Feed(b.opts.BatchSize, b.opts.Epoch, func(emit func(int)) error {
+		return b.readCorpus(func(r io.Reader) error {
+			scanner := bufio.NewScanner(r)
+			scanner.Split(scanChunks)
+			for scanner.Scan() {
+				symbols, ok := cache[scanner.Text()]
+				if !ok {
+					symbols = encode(scanner.Bytes(), b.merges, b.ranks)
+					if len(cache) < cacheSize {
+						cache[scanner.Text()] = symbols
+					}
+				}
+				for _, symbol := range symbols {
+					emit(symbol)
+				}
+			}
+			return scanner.Err()
+		})
+	})
+}
+
+// NewRNN returns a neural network suitable for this codec
+func (b *BPE) NewRNN() *rnn.RNN {
+	return rnn.NewRNN(len(b.symbols), len(b.symbols))
+}
+
+// ApplyDist applies  a distribution according to the configuration of the neural network
+func (b *BPE) ApplyDist(p []float64) []float64 {
+	return b.opts.ApplyDist(p)
+}
+
+// Filters returns the repetition controls applied during the generation
+func (b *BPE) Filters() []rnn.Filter {
+	return b.opts.Filters()
+}
+
+// Allow returns a filter that restricts the generation to the symbols
+// made of allowed runes; the symbols holding an incomplete UTF-8 sequence are not allowed
+func (b *BPE) Allow(allowed func(rune) bool) rnn.Filter {
+	return rnn.Constrain(func(ix int, _ [][]float64) bool {
+		if ix >= len(b.symbols) || !utf8.Valid(b.symbols[ix]) {
+			return false
+		}
+		for _, r := range string(b.symbols[ix]) {
+			if !allowed(r) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// SetLoss sets the loss and the smoothLoss
+func (b *BPE) SetLoss(loss float64) {
+	b.loss = loss
+	b.smoothLoss = b.smoothLoss*0.999 + loss*0.001
+}
+
+// Infos ...
+type Infos struct {
+	SmoothLoss float64
+}
+
+// MarshalJSON ...
+func (i Infos) MarshalJSON() ([]byte, error) {
+	type infos Infos
+	return json.Marshal(infos(i))
+}
+
+// GetInfos ...
+func (b *BPE) GetInfos() json.Marshaler {
+	return Infos{
+		b.smoothLoss,
+	}
+}
+
+type backupStruct struct {
+	Loss       float64
+	SmoothLoss float64
+	// Merges holds the merged pairs of symbols, in the order they were learned
+	Merges    [][2]int
+	BatchSize int
+}
+
+// MarshalBinary ...
+func (b *BPE) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	merges := make([][2]int, len(b.merges))
+	for i, p := range b.merges {
+		merges[i] = p
+	}
+	enc := gob.NewEncoder(buf)
+	err := enc.Encode(backupStruct{
+		Loss:       b.loss,
+		SmoothLoss: b.smoothLoss,
+		Merges:     merges,
+		BatchSize:  b.opts.BatchSize,
+	})
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary ...
+func (b *BPE) UnmarshalBinary(data []byte) error {
+	var t backupStruct
+	dec := gob.NewDecoder(bytes.NewBuffer(data))
+	err := dec.Decode(&t)
+	b.loss = t.Loss
+	b.smoothLoss = t.SmoothLoss
+	b.opts.BatchSize = t.BatchSize
+	merges := make([]pair, len(t.Merges))
+	for i, p := range t.Merges {
+		merges[i] = p
+	}
+	b.setMerges(merges)
+	return err
+}
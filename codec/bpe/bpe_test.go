@@ -0,0 +1,66 @@
+package bpe
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRoundTrip(t *testing.T) {
+	corpus := "the cat and the hat and the bat\nthe end\n"
+	merges, err := learnMerges(strings.NewReader(corpus), 270)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merges) == 0 || len(merges) > 270-alphabetSize {
+		t.Fatalf("bad number of merges: %v", len(merges))
+	}
+	b := &BPE{}
+	b.setMerges(merges)
+	text := "the other hat, éè\n"
+	xs := b.Encode(strings.NewReader(text))
+	if len(xs) >= len(text) {
+		t.Fatal("the text should have been compressed")
+	}
+	var out bytes.Buffer
+	out.ReadFrom(b.Decode(xs))
+	if out.String() != text {
+		t.Fatalf("bad round trip: %q", out.String())
+	}
+	bin, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &BPE{}
+	if err := restored.UnmarshalBinary(bin); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Encode(strings.NewReader(text))) != len(xs) {
+		t.Fatal("the restored codec does not encode the same way")
+	}
+}
+
+func TestLongChunks(t *testing.T) {
+	text := strings.Repeat("a", 100000) + " " + strings.Repeat("é", 50000) + "\n"
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Split(scanChunks)
+	var joined strings.Builder
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > maxChunk || !utf8.Valid(scanner.Bytes()) {
+			t.Fatalf("bad chunk %q", scanner.Text())
+		}
+		joined.Write(scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if joined.String() != text {
+		t.Fatal("the chunks do not make the text")
+	}
+	// The long runs are learned like the other chunks
+	if _, err := learnMerges(strings.NewReader(text), 260); err != nil {
+		t.Fatal(err)
+	}
+}
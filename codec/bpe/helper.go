@@ -0,0 +1,137 @@
+package bpe
+
+import (
+	"bufio"
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The 256 first symbols are the bytes; the symbol 256+i is the result of the i-th merge
+const alphabetSize = 256
+
+// maxChunk is the maximum length in bytes of a chunk; the longer runs are split
+const maxChunk = 64
+
+type pair [2]int
+
+// scanChunks is a bufio.SplitFunc that splits the input into chunks made of
+// the spaces followed by the non-space characters ("hello world\n" gives "hello", " world" and "\n").
+// The chunks longer than maxChunk bytes are split on a rune boundary.
+// The merges never cross the boundaries of a chunk
+func scanChunks(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	i := 0
+	inWord := false
+	for i < len(data) {
+		if !atEOF && !utf8.FullRune(data[i:]) {
+			break
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		space := unicode.IsSpace(r)
+		if inWord && space || i > 0 && i+size > maxChunk {
+			return i, data[:i], nil
+		}
+		if !space {
+			inWord = true
+		}
+		i += size
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	// Request more data
+	return 0, nil, nil
+}
+
+// learnMerges reads the corpus and learns the merges that
+// give the most frequent pairs of symbols, until the vocabulary
+// reaches vocabSize symbols or no pair occurs twice
+func learnMerges(r io.Reader, vocabSize int) ([]pair, error) {
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanChunks)
+	for scanner.Scan() {
+		counts[scanner.Text()]++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	type word struct {
+		symbols []int
+		count   int
+	}
+	words := make([]word, 0, len(counts))
+	for w, c := range counts {
+		symbols := make([]int, len(w))
+		for i := 0; i < len(w); i++ {
+			symbols[i] = int(w[i])
+		}
+		words = append(words, word{symbols, c})
+	}
+	var merges []pair
+	for len(merges) < vocabSize-alphabetSize {
+		pairs := make(map[pair]int)
+		for _, w := range words {
+			for i := 0; i+1 < len(w.symbols); i++ {
+				pairs[pair{w.symbols[i], w.symbols[i+1]}] += w.count
+			}
+		}
+		var best pair
+		bestCount := 1
+		for p, c := range pairs {
+			if c > bestCount || c == bestCount && bestCount > 1 && less(p, best) {
+				best, bestCount = p, c
+			}
+		}
+		if bestCount < 2 {
+			break
+		}
+		symbol := alphabetSize + len(merges)
+		for i := range words {
+			words[i].symbols = merge(words[i].symbols, best, symbol)
+		}
+		merges = append(merges, best)
+	}
+	return merges, nil
+}
+
+// less orders the pairs to break the ties deterministically
+func less(a, b pair) bool {
+	return a[0] < b[0] || a[0] == b[0] && a[1] < b[1]
+}
+
+// merge replaces, from left to right, the occurrences of p in the symbols
+func merge(symbols []int, p pair, symbol int) []int {
+	out := symbols[:0]
+	for i := 0; i < len(symbols); i++ {
+		if i+1 < len(symbols) && symbols[i] == p[0] && symbols[i+1] == p[1] {
+			out = append(out, symbol)
+			i++
+			continue
+		}
+		out = append(out, symbols[i])
+	}
+	return out
+}
+
+// encode a chunk by applying the merges in the order they were learned;
+// ranks maps a merged pair to its index in merges
+func encode(chunk []byte, merges []pair, ranks map[pair]int) []int {
+	symbols := make([]int, len(chunk))
+	for i, b := range chunk {
+		symbols[i] = int(b)
+	}
+	for len(symbols) > 1 {
+		rank := -1
+		for i := 0; i+1 < len(symbols); i++ {
+			if r, ok := ranks[pair{symbols[i], symbols[i+1]}]; ok && (rank == -1 || r < rank) {
+				rank = r
+			}
+		}
+		if rank == -1 {
+			break
+		}
+		symbols = merge(symbols, merges[rank], alphabetSize+rank)
+	}
+	return symbols
+}
@@ -0,0 +1,214 @@
+// Package bytes implements a codec that feeds the RNN with the raw bytes of the input.
+// Its vocabulary is fixed to the 256 possible bytes: it does not need any vocabulary file
+// and it handles invalid UTF-8, binary formats and any language.
+package bytes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"unicode/utf8"
+
+	"github.com/owulveryck/min-char-rnn/codec"
+	"github.com/owulveryck/min-char-rnn/codec/source"
+	"github.com/owulveryck/min-char-rnn/rnn"
+)
+
+// Options of the bytes codec
+type Options struct {
+	// Sources are the parts of the training corpus, read in sequence at every epoch
+	Sources []source.Source
+	// BatchSize is the length of the sequences of the training sets
+	BatchSize int
+	// Epoch is the number of times the training corpus is read
+	Epoch int
+	codec.Windowing
+	codec.Sampling
+}
+
+const vocabSize = 256
+
+// Bytes is the codec for feeding a RNN with raw bytes
+type Bytes struct {
+	loss       float64
+	smoothLoss float64
+	opts       Options
+	corpus     *source.Corpus
+}
+
+func init() {
+	gob.Register(&Bytes{})
+	codec.Register("bytes", func() codec.Codec {
+		return &Bytes{}
+	})
+}
+
+// New creates a bytes codec
+func New(opts Options) (*Bytes, error) {
+	b := &Bytes{
+		smoothLoss: -math.Log(float64(1)/float64(vocabSize)) * float64(opts.BatchSize),
+	}
+	return b, b.SetOptions(opts)
+}
+
+// SetOptions replaces the options of the codec.
+// It is used to configure a restored codec for a new training
+func (b *Bytes) SetOptions(opts Options) error {
+	if opts.BatchSize <= 0 {
+		return errors.New("BatchSize must be positive")
+	}
+	corpus, err := source.NewCorpus(opts.Sources...)
+	if err != nil {
+		return err
+	}
+	b.opts = opts
+	b.corpus = corpus
+	return nil
+}
+
+// SetSampling replaces the options of the generation
+func (b *Bytes) SetSampling(s codec.Sampling) {
+	b.opts.Sampling = s
+}
+
+func oneOfK(c byte) []float64 {
+	oneOfK := make([]float64, vocabSize)
+	oneOfK[c] = 1
+	return oneOfK
+}
+
+// Decode an array of inputs and returns an io.Reader on the raw bytes
+// the input is an array of 1-of-K encoded vectors
+func (b *Bytes) Decode(xs [][]float64) io.Reader {
+	output := make([]byte, len(xs))
+	for i, x := range xs {
+		idx := 0
+		for idx = range x {
+			if x[idx] == 1 {
+				break
+			}
+		}
+		output[i] = byte(idx)
+	}
+	return bytes.NewReader(output)
+}
+
+// Encode the io.Reader into an slice composed of
+// 1-of-K encoded vectors
+func (b *Bytes) Encode(r io.Reader) [][]float64 {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	xs := make([][]float64, len(data))
+	for i, c := range data {
+		xs[i] = oneOfK(c)
+	}
+	return xs
+}
+
+// Feed returns a channel that will be filled with TrainingSets
+// its triggers a go-routine that reads the inputs and
+// that is putting some data in the channel
+func (b *Bytes) Feed() <-chan rnn.TrainingSet {
+	return b.opts.Windowing.Feed(b.opts.BatchSize, b.opts.Epoch, func(emit func(int)) error {
+		rdr, err := b.corpus.Next()
+		if err != nil {
+			return err
+		}
+		defer rdr.Close()
+		r := bufio.NewReader(rdr)
+		for {
+			c, err := r.ReadByte()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			emit(int(c))
+		}
+	})
+}
+
+// NewRNN returns a neural network suitable for this codec
+func (b *Bytes) NewRNN() *rnn.RNN {
+	return rnn.NewRNN(vocabSize, vocabSize)
+}
+
+// ApplyDist applies  a distribution according to the configuration of the neural network
+func (b *Bytes) ApplyDist(p []float64) []float64 {
+	return b.opts.ApplyDist(p)
+}
+
+// Filters returns the repetition controls applied during the generation
+func (b *Bytes) Filters() []rnn.Filter {
+	return b.opts.Filters()
+}
+
+// Allow returns a filter that restricts the generation to the allowed runes.
+// Only the ASCII runes can be expressed by a single byte; the other bytes are never allowed
+func (b *Bytes) Allow(allowed func(rune) bool) rnn.Filter {
+	return rnn.Constrain(func(ix int, _ [][]float64) bool {
+		return ix < utf8.RuneSelf && allowed(rune(ix))
+	})
+}
+
+// SetLoss sets the loss and the smoothLoss
+func (b *Bytes) SetLoss(loss float64) {
+	b.loss = loss
+	b.smoothLoss = b.smoothLoss*0.999 + loss*0.001
+}
+
+// Infos ...
+type Infos struct {
+	SmoothLoss float64
+}
+
+// MarshalJSON ...
+func (i Infos) MarshalJSON() ([]byte, error) {
+	type infos Infos
+	return json.Marshal(infos(i))
+}
+
+// GetInfos ...
+func (b *Bytes) GetInfos() json.Marshaler {
+	return Infos{
+		b.smoothLoss,
+	}
+}
+
+type backupStruct struct {
+	Loss       float64
+	SmoothLoss float64
+	BatchSize  int
+}
+
+// MarshalBinary ...
+func (b *Bytes) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := gob.NewEncoder(buf)
+	err := enc.Encode(backupStruct{
+		Loss:       b.loss,
+		SmoothLoss: b.smoothLoss,
+		BatchSize:  b.opts.BatchSize,
+	})
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary ...
+func (b *Bytes) UnmarshalBinary(data []byte) error {
+	var t backupStruct
+	dec := gob.NewDecoder(bytes.NewBuffer(data))
+	err := dec.Decode(&t)
+	b.loss = t.Loss
+	b.smoothLoss = t.SmoothLoss
+	b.opts.BatchSize = t.BatchSize
+	return err
+}
@@ -0,0 +1,85 @@
+package bytes
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/owulveryck/min-char-rnn/codec"
+	"github.com/owulveryck/min-char-rnn/codec/source"
+)
+
+func TestRoundTrip(t *testing.T) {
+	// Every byte value, followed by invalid UTF-8
+	var data []byte
+	for i := 0; i < vocabSize; i++ {
+		data = append(data, byte(i))
+	}
+	data = append(data, "\xff\xfe\xc3(\xe2\x82"...)
+	b := &Bytes{}
+	xs := b.Encode(bytes.NewReader(data))
+	if len(xs) != len(data) {
+		t.Fatalf("expected %v vectors, got %v", len(data), len(xs))
+	}
+	for i, x := range xs {
+		if len(x) != vocabSize || x[data[i]] != 1 {
+			t.Fatalf("bad encoding of the byte %v", data[i])
+		}
+	}
+	decoded, err := ioutil.ReadAll(b.Decode(xs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Fatalf("the bytes are not decoded back: %q", decoded)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	b, err := New(Options{
+		Sources:   []source.Source{{Reader: strings.NewReader("hello, world")}},
+		BatchSize: 3,
+		Epoch:     1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inputs, targets []string
+	for tset := range b.Feed() {
+		var in, target []byte
+		for i := range tset.InputIndexes {
+			in = append(in, byte(tset.InputIndexes[i]))
+			target = append(target, byte(tset.TargetIndexes[i]))
+		}
+		inputs = append(inputs, string(in))
+		targets = append(targets, string(target))
+	}
+	// Windows of 4 bytes; the last, incomplete one is dropped
+	if strings.Join(inputs, "|") != "hel|o, |orl" || strings.Join(targets, "|") != "ell|, w|rld" {
+		t.Fatalf("bad training sets: inputs %q, targets %q", inputs, targets)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	b, err := New(Options{BatchSize: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetLoss(3)
+	bkp, err := codec.Save(b, b.NewRNN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := codec.Restore(bkp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, ok := c.(*Bytes)
+	if !ok {
+		t.Fatalf("bad codec type %T", c)
+	}
+	if restored.opts.BatchSize != 7 || restored.loss != 3 || restored.smoothLoss != b.smoothLoss {
+		t.Fatalf("bad restored codec %+v", restored)
+	}
+}
@@ -6,90 +6,86 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"math"
-	"math/rand"
-	"os"
-	"time"
 
-	"github.com/kelseyhightower/envconfig"
+	"github.com/owulveryck/min-char-rnn/codec"
+	"github.com/owulveryck/min-char-rnn/codec/source"
 	"github.com/owulveryck/min-char-rnn/rnn"
-	"gonum.org/v1/gonum/stat/distuv"
 )
 
-type trainingConfiguration struct {
-	Epoch     int    `default:"100" required:"true"`
-	Input     string `envconfig:"input_file" default:"" required:"true"`
-	VocabFile string `envconfig:"vocab_file" default:"" required:"true"`
-	BatchSize int    `envconfig:"BATCH_SIZE" default:"25" required:"true"`
-	Choice    string `ignored:"true"` // Ignored because parsed in the other structure
-}
-
-type predictConfiguration struct {
-	Choice string `default:"hard" required:"true"`
-}
-
-var conf trainingConfiguration
-
-const envPrefix = "CHAR_CODEC"
-
-// Configure the codec via environment variables
-func Configure() error {
-
-	err := envconfig.Process(envPrefix, &conf)
-	if err != nil {
-		return err
-	}
-	var s predictConfiguration
-	err = envconfig.Process(envPrefix, &s)
-	if err != nil {
-		return err
-	}
-	conf.Choice = s.Choice
-	if conf.BatchSize == 0 {
-		return errors.New("BATCH_SIZE cannot be null")
-	}
-	if _, err := os.Stat(conf.VocabFile); err != nil {
-		return err
-	}
-	if _, err := os.Stat(conf.Input); err != nil {
-		return err
-	}
-	return nil
+// Options of the char codec
+type Options struct {
+	// Sources are the parts of the training corpus, read in sequence at every epoch
+	Sources []source.Source
+	// VocabFile holds all the runes of the vocabulary
+	VocabFile string
+	// BatchSize is the length of the sequences of the training sets
+	BatchSize int
+	// Epoch is the number of times the training corpus is read
+	Epoch int
+	codec.Windowing
+	codec.Sampling
 }
 
 // Char is the basic codec for feeding a RNN with text
 type Char struct {
 	loss       float64
 	smoothLoss float64
-	batchSize  int
 	runesToIx  map[rune]int
 	ixToRunes  map[int]rune
+	opts       Options
+	corpus     *source.Corpus
 }
 
 func init() {
 	gob.Register(&Char{})
+	codec.Register("char", func() codec.Codec {
+		return &Char{}
+	})
 }
 
-// NewChar ...
-func NewChar() (*Char, error) {
-	err := Configure()
+// New creates a char codec whose vocabulary is read from opts.VocabFile
+func New(opts Options) (*Char, error) {
+	runesToIx, ixToRunes, err := getVocabIndexesFromFile(opts.VocabFile)
 	if err != nil {
 		return nil, err
 	}
-	runesToIx, ixToRunes, err := getVocabIndexesFromFile(conf.VocabFile)
-	if err != nil {
-		return nil, err
-	}
-	return &Char{
+	c := &Char{
 		loss:       0,
-		batchSize:  conf.BatchSize,
 		ixToRunes:  ixToRunes,
 		runesToIx:  runesToIx,
-		smoothLoss: -math.Log(float64(1)/float64(len(runesToIx))) * float64(conf.BatchSize),
-	}, nil
+		smoothLoss: -math.Log(float64(1)/float64(len(runesToIx))) * float64(opts.BatchSize),
+	}
+	return c, c.SetOptions(opts)
+}
+
+// SetOptions replaces the options of the codec; the vocabulary is left untouched.
+// It is used to configure a restored codec for a new training
+func (c *Char) SetOptions(opts Options) error {
+	if opts.BatchSize <= 0 {
+		return errors.New("BatchSize must be positive")
+	}
+	corpus, err := source.NewCorpus(opts.Sources...)
+	if err != nil {
+		return err
+	}
+	c.opts = opts
+	c.corpus = corpus
+	return nil
+}
+
+// SetSampling replaces the options of the generation
+func (c *Char) SetSampling(s codec.Sampling) {
+	c.opts.Sampling = s
+}
+
+// oneOfK returns the 1-of-K encoded vector of the rune of index ix
+func (c *Char) oneOfK(ix int) []float64 {
+	oneOfK := make([]float64, len(c.runesToIx))
+	oneOfK[ix] = 1
+	return oneOfK
 }
 
 // Decode an array of inputs and returns an io.Reader
@@ -106,7 +102,6 @@ func (c *Char) Decode(xs [][]float64) io.Reader {
 			}
 		}
 		_, err := buf.WriteRune(c.ixToRunes[idx])
-		fmt.Printf("%c", c.ixToRunes[idx])
 		if err != nil {
 			log.Println(err)
 		}
@@ -131,70 +126,34 @@ func (c *Char) Encode(r io.Reader) [][]float64 {
 			}
 			log.Fatal(err)
 		} else {
-			oneOfK := make([]float64, len(c.runesToIx))
-			oneOfK[c.runesToIx[char]] = 1
-			xs = append(xs, oneOfK)
+			xs = append(xs, c.oneOfK(c.runesToIx[char]))
 		}
 	}
 	return xs
 }
 
 // Feed returns a channel that will be filled with TrainingSets
-// its triggers a go-routine that reads the input and
+// its triggers a go-routine that reads the inputs and
 // that is putting some data in the channel
 func (c *Char) Feed() <-chan rnn.TrainingSet {
-	feed := make(chan rnn.TrainingSet, 1)
-	err := Configure()
-	if err != nil {
-		return nil
-	}
-	rdr, err := os.Open(conf.Input)
-	if err != nil {
-		return nil
-	}
-	go func(feed chan<- rnn.TrainingSet) {
+	return c.opts.Windowing.Feed(c.opts.BatchSize, c.opts.Epoch, func(emit func(int)) error {
+		rdr, err := c.corpus.Next()
+		if err != nil {
+			return err
+		}
 		defer rdr.Close()
 		r := bufio.NewReader(rdr)
-		tset := rnn.TrainingSet{
-			Inputs:  make([][]float64, conf.BatchSize),
-			Targets: make([][]float64, conf.BatchSize),
-		}
-		for epoch := 0; epoch < conf.Epoch; epoch++ {
-			if _, err := rdr.Seek(0, io.SeekStart); err != nil {
-				log.Fatal(err)
+		for {
+			char, _, err := r.ReadRune()
+			if err == io.EOF {
+				return nil
 			}
-			for {
-				var char rune
-				var err error
-				for i := 0; i < conf.BatchSize+1; i++ {
-					if char, _, err = r.ReadRune(); err != nil {
-						if err == io.EOF {
-							break
-						}
-						log.Fatal(err)
-					}
-					oneOfK := make([]float64, len(c.runesToIx))
-					oneOfK[c.runesToIx[char]] = 1
-
-					switch i {
-					case 0:
-						tset.Inputs[i] = oneOfK
-					case conf.BatchSize:
-						tset.Targets[i-1] = oneOfK
-					default:
-						tset.Inputs[i] = oneOfK
-						tset.Targets[i-1] = oneOfK
-					}
-				}
-				if err == io.EOF {
-					break
-				}
-				feed <- rnn.CopyOf(tset)
+			if err != nil {
+				return err
 			}
+			emit(c.runesToIx[char])
 		}
-		close(feed)
-	}(feed)
-	return feed
+	})
 }
 
 // NewRNN returns a neural network suitable for this codec
@@ -204,24 +163,20 @@ func (c *Char) NewRNN() *rnn.RNN {
 
 // ApplyDist applies  a distribution according to the configuration of the neural network
 func (c *Char) ApplyDist(p []float64) []float64 {
-	output := make([]float64, len(p))
-	switch conf.Choice {
-	case "soft":
-		sample := distuv.NewCategorical(p, rand.New(rand.NewSource(time.Now().UnixNano())))
-		output[int(sample.Rand())] = 1
-	default:
-		best := float64(0)
-		bestIdx := 0
-		for i, v := range p {
-			if v > best {
-				best = v
-				bestIdx = i
-			}
-		}
-		output[bestIdx] = 1
-	}
+	return c.opts.ApplyDist(p)
+}
+
+// Allow returns a filter that restricts the generation to the allowed runes
+func (c *Char) Allow(allowed func(rune) bool) rnn.Filter {
+	return rnn.Constrain(func(ix int, _ [][]float64) bool {
+		r, ok := c.ixToRunes[ix]
+		return ok && allowed(r)
+	})
+}
 
-	return output
+// Filters returns the repetition controls applied during the generation
+func (c *Char) Filters() []rnn.Filter {
+	return c.opts.Filters()
 }
 
 // SetLoss sets the loss and the smoothLoss
@@ -263,7 +218,7 @@ func (c *Char) MarshalBinary() ([]byte, error) {
 	t.SmoothLoss = c.smoothLoss
 	t.RunesToIx = c.runesToIx
 	t.IxToRunes = c.ixToRunes
-	t.BatchSize = conf.BatchSize
+	t.BatchSize = c.opts.BatchSize
 	enc := gob.NewEncoder(buf)
 	err := enc.Encode(t)
 
@@ -272,21 +227,15 @@ func (c *Char) MarshalBinary() ([]byte, error) {
 
 // UnmarshalBinary ...
 func (c *Char) UnmarshalBinary(b []byte) error {
-	var s predictConfiguration
-	err := envconfig.Process(envPrefix, &s)
-	if err != nil {
-		return err
-	}
-	conf.Choice = s.Choice
 	buf := bytes.NewBuffer(b)
 	var t backupStruct
 	dec := gob.NewDecoder(buf)
-	err = dec.Decode(&t)
+	err := dec.Decode(&t)
 	c.loss = t.Loss
 	c.smoothLoss = t.SmoothLoss
 	c.runesToIx = t.RunesToIx
 	c.ixToRunes = t.IxToRunes
-	conf.BatchSize = t.BatchSize
+	c.opts.BatchSize = t.BatchSize
 
 	return err
 }
@@ -0,0 +1,98 @@
+package char
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"unicode"
+
+	"github.com/owulveryck/min-char-rnn/codec"
+	"github.com/owulveryck/min-char-rnn/rnn"
+)
+
+// newChar returns a codec whose vocabulary is made of the runes of text
+func newChar(text string) *Char {
+	runesToIx, ixToRunes := getVocabIndexes([]byte(text))
+	return &Char{runesToIx: runesToIx, ixToRunes: ixToRunes}
+}
+
+// uniform returns a uniform distribution over n elements
+func uniform(n int) []float64 {
+	p := make([]float64, n)
+	for i := range p {
+		p[i] = 1 / float64(n)
+	}
+	return p
+}
+
+func TestAllow(t *testing.T) {
+	c := newChar("a1b2 c3\n")
+	p := uniform(len(c.runesToIx))
+	c.Allow(unicode.IsDigit)(p, nil)
+	for ix, r := range c.ixToRunes {
+		switch {
+		case unicode.IsDigit(r) && p[ix] != float64(1)/3:
+			t.Errorf("%q should have a probability of 1/3, got %v", r, p[ix])
+		case !unicode.IsDigit(r) && p[ix] != 0:
+			t.Errorf("%q should not be allowed, got a probability of %v", r, p[ix])
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	c := newChar("abc")
+	if len(c.Filters()) != 0 {
+		t.Fatal("no filter should be applied by default")
+	}
+	c.SetSampling(codec.Sampling{PresencePenalty: 1, NoRepeatNgram: 2})
+	filters := c.Filters()
+	if len(filters) != 2 {
+		t.Fatalf("expected a penalty and an n-gram blocking, got %v filters", len(filters))
+	}
+	// After "aba", "b" would repeat the bigram "ab" and "a" is penalized
+	history := c.Encode(bytes.NewBufferString("aba"))
+	p := uniform(3)
+	for _, f := range filters {
+		f(p, history)
+	}
+	a, b, cc := p[c.runesToIx['a']], p[c.runesToIx['b']], p[c.runesToIx['c']]
+	if b != 0 || a == 0 || a >= cc {
+		t.Fatalf("bad distribution: a %v, b %v, c %v", a, b, cc)
+	}
+}
+
+func TestRestoreUnnamed(t *testing.T) {
+	c := newChar("hello\n")
+	c.opts.BatchSize = 5
+	c.SetLoss(2)
+	cdc, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The backups made before the registry do not record the codec
+	old := struct {
+		Cdc []byte
+		Rnn rnn.RNN
+	}{cdc, *c.NewRNN()}
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(&old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, _, err := codec.Restore(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := restored.(*Char)
+	if !ok {
+		t.Fatalf("expected a char codec, got %T", restored)
+	}
+	if r.opts.BatchSize != 5 || r.smoothLoss != c.smoothLoss || len(r.runesToIx) != len(c.runesToIx) {
+		t.Fatalf("bad restored codec %+v", r)
+	}
+	for ix, char := range c.ixToRunes {
+		if r.ixToRunes[ix] != char || r.runesToIx[char] != ix {
+			t.Fatalf("the vocabulary is not restored: %q at %v", char, ix)
+		}
+	}
+}
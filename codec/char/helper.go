@@ -16,20 +16,16 @@ func getVocabIndexesFromFile(filename string) (map[rune]int, map[int]rune, error
 
 // getVocabIndexes reads all the input, fill in an array of runes,
 // and returns a map that maps a rune to its index, and another
-// one that maps the index to the rune
+// one that maps the index to the rune.
+// The runes are indexed in the order of their first occurrence, so that the indexes do not change from a run to another
 func getVocabIndexes(input []byte) (map[rune]int, map[int]rune) {
-	// Extract the rune list
 	runeToIx := make(map[rune]int)
-	data := bytes.Runes(input)
-	for _, v := range data {
-		runeToIx[v] = 0
-	}
-	ixToRune := make(map[int]rune, len(runeToIx))
-	i := 0
-	for k := range runeToIx {
-		runeToIx[k] = i
-		ixToRune[i] = k
-		i++
+	ixToRune := make(map[int]rune)
+	for _, v := range bytes.Runes(input) {
+		if _, ok := runeToIx[v]; !ok {
+			runeToIx[v] = len(ixToRune)
+			ixToRune[len(ixToRune)] = v
+		}
 	}
 	return runeToIx, ixToRune
 
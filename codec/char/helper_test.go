@@ -0,0 +1,20 @@
+package char
+
+import "testing"
+
+func TestGetVocabIndexes(t *testing.T) {
+	input := []byte("abcab dé\ndé")
+	expected := []rune("abc dé\n")
+	for n := 0; n < 10; n++ {
+		runeToIx, ixToRune := getVocabIndexes(input)
+		if len(runeToIx) != len(expected) || len(ixToRune) != len(expected) {
+			t.Fatalf("expected %v runes, got %v and %v", len(expected), len(runeToIx), len(ixToRune))
+		}
+		// The runes are indexed in the order of their first occurrence, at every run
+		for i, r := range expected {
+			if runeToIx[r] != i || ixToRune[i] != r {
+				t.Fatalf("expected %q at %v, got %v and %q", r, i, runeToIx[r], ixToRune[i])
+			}
+		}
+	}
+}
@@ -25,21 +25,45 @@ type Codec interface {
 	UnmarshalBinary([]byte) error
 }
 
+// Constrainer is implemented by the codecs able to restrict the generation
+// to the elements made of allowed runes
+type Constrainer interface {
+	// Allow returns a filter that only lets the elements whose runes are allowed
+	// to be generated
+	Allow(allowed func(rune) bool) rnn.Filter
+}
+
+// Filterer is implemented by the codecs that configure filters
+// (such as repetition controls) for the generation
+type Filterer interface {
+	Filters() []rnn.Filter
+}
+
 // Backup ...
 type backup struct {
-	Cdc []byte
-	Rnn rnn.RNN
+	// Name of the codec in the registry
+	Codec string
+	Cdc   []byte
+	Rnn   rnn.RNN
 }
 
-// Save the Codec and the RNN for future use
+// the backups that do not record the codec have been made by the char codec
+const defaultCodec = "char"
+
+// Save the Codec and the RNN for future use.
+// The name under which the codec is registered is saved along with it
 func Save(c Codec, r *rnn.RNN) ([]byte, error) {
+	name, err := Name(c)
+	if err != nil {
+		return nil, err
+	}
 	var cdcb []byte
-	var err error
 	cdcb, err = c.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
 	bkp := backup{
+		name,
 		cdcb,
 		*r,
 	}
@@ -49,8 +73,9 @@ func Save(c Codec, r *rnn.RNN) ([]byte, error) {
 	return output.Bytes(), err
 }
 
-// Restore the learner and the RNN
-func Restore(b []byte) ([]byte, *rnn.RNN, error) {
+// Restore the learner and the RNN.
+// The codec is created from the registry; its package must have been imported
+func Restore(b []byte) (Codec, *rnn.RNN, error) {
 	var bkp backup
 	input := bytes.NewBuffer(b)
 	dec := gob.NewDecoder(input)
@@ -58,6 +83,27 @@ func Restore(b []byte) ([]byte, *rnn.RNN, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	if bkp.Codec == "" {
+		bkp.Codec = defaultCodec
+	}
+	c, err := newCodec(bkp.Codec)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = c.UnmarshalBinary(bkp.Cdc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, &bkp.Rnn, nil
+}
 
-	return bkp.Cdc, &bkp.Rnn, err
+// Evaluate scores the text read from r against the RNN.
+// The text is encoded with the codec; the optional prefix (it may be nil)
+// conditions the network before the scoring
+func Evaluate(c Codec, r *rnn.RNN, prefix, text io.Reader) rnn.Evaluation {
+	var xs [][]float64
+	if prefix != nil {
+		xs = c.Encode(prefix)
+	}
+	return r.Evaluate(xs, c.Encode(text))
 }
@@ -0,0 +1,134 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/owulveryck/min-char-rnn/rnn"
+)
+
+type dummy struct {
+	state string
+}
+
+func (d *dummy) Decode([][]float64) io.Reader    { return nil }
+func (d *dummy) Encode(io.Reader) [][]float64    { return nil }
+func (d *dummy) Feed() <-chan rnn.TrainingSet    { return nil }
+func (d *dummy) NewRNN() *rnn.RNN                { return rnn.NewRNN(2, 2) }
+func (d *dummy) ApplyDist(p []float64) []float64 { return p }
+func (d *dummy) SetLoss(float64)                 {}
+func (d *dummy) GetInfos() json.Marshaler        { return nil }
+func (d *dummy) MarshalBinary() ([]byte, error)  { return []byte(d.state), nil }
+func (d *dummy) UnmarshalBinary(b []byte) error  { d.state = string(b); return nil }
+
+func TestSaveRestore(t *testing.T) {
+	Register("dummy", func() Codec { return &dummy{} })
+	c := &dummy{"state"}
+	b, err := Save(c, c.NewRNN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, _, err := Restore(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, ok := restored.(*dummy)
+	if !ok {
+		t.Fatalf("bad codec type %T", restored)
+	}
+	if d.state != "state" {
+		t.Fatalf("bad codec state %q", d.state)
+	}
+}
+
+// windows collects the first elements of the training sets and their reset flag
+func windows(w Windowing, batchSize, epochs, n int) ([]int, []bool) {
+	var starts []int
+	var resets []bool
+	for tset := range w.Feed(batchSize, epochs, func(emit func(int)) error {
+		for i := 0; i < n; i++ {
+			emit(i)
+		}
+		return nil
+	}) {
+		for i := range tset.TargetIndexes {
+			if tset.TargetIndexes[i] != tset.InputIndexes[i]+1 {
+				panic("targets do not follow the inputs")
+			}
+		}
+		starts = append(starts, tset.InputIndexes[0])
+		resets = append(resets, tset.Reset)
+	}
+	return starts, resets
+}
+
+// streams collects the first elements of the streams of the mini-batches and their reset flag
+func streams(w Windowing, batchSize, n int) ([][]int, [][]bool) {
+	var starts [][]int
+	var resets [][]bool
+	for tset := range w.Feed(batchSize, 1, func(emit func(int)) error {
+		for i := 0; i < n; i++ {
+			emit(i)
+		}
+		return nil
+	}) {
+		var s []int
+		var r []bool
+		for _, stream := range tset.Streams {
+			s = append(s, stream.InputIndexes[0])
+			r = append(r, stream.Reset)
+		}
+		starts = append(starts, s)
+		resets = append(resets, r)
+	}
+	return starts, resets
+}
+
+func TestWindowing(t *testing.T) {
+	starts, resets := windows(Windowing{}, 3, 2, 10)
+	if fmt.Sprint(starts, resets) != "[0 4 0 4] [false false false false]" {
+		t.Errorf("sequential: got %v %v", starts, resets)
+	}
+	starts, resets = windows(Windowing{Stride: 2}, 3, 1, 10)
+	if fmt.Sprint(starts, resets) != "[0 2 4 6] [false true true true]" {
+		t.Errorf("overlapping: got %v %v", starts, resets)
+	}
+	starts, resets = windows(Windowing{Stride: 3}, 3, 1, 10)
+	if fmt.Sprint(starts, resets) != "[0 3 6] [false false false]" {
+		t.Errorf("contiguous: got %v %v", starts, resets)
+	}
+	// 10 windows of 4 elements read by 2 streams
+	starts2, resets2 := streams(Windowing{Streams: 2}, 3, 40)
+	if fmt.Sprint(starts2) != "[[0 20] [4 24] [8 28] [12 32] [16 36]]" {
+		t.Errorf("streams: got %v", starts2)
+	}
+	if strings.Contains(fmt.Sprint(resets2), "true") {
+		t.Errorf("the streams read contiguous windows: got %v", resets2)
+	}
+	// 11 windows read by blocks of 2*2 windows, the last one left over
+	starts2, resets2 = streams(Windowing{Streams: 2, StreamLength: 2}, 3, 44)
+	if fmt.Sprint(starts2) != "[[0 8] [4 12] [16 24] [20 28] [32 36] [40]]" {
+		t.Errorf("blocks: got %v", starts2)
+	}
+	if fmt.Sprint(resets2) != "[[false false] [false false] [true true] [false false] [true true] [true]]" {
+		t.Errorf("blocks: got resets %v", resets2)
+	}
+	for _, buffer := range []int{0, 2} {
+		w := Windowing{Stride: 3, Shuffle: true, ShuffleBuffer: buffer, RandomOffset: true, Seed: 1}
+		starts, _ := windows(w, 3, 20, 100)
+		again, _ := windows(w, 3, 20, 100)
+		if fmt.Sprint(starts) != fmt.Sprint(again) {
+			t.Errorf("shuffle(%v) is not deterministic", buffer)
+		}
+		seen := make(map[int]bool)
+		for _, s := range starts {
+			seen[s] = true
+		}
+		if len(seen) < 50 {
+			t.Errorf("shuffle(%v): the random offsets give only %v windows", buffer, len(seen))
+		}
+	}
+}
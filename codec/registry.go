@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]func() Codec)
+)
+
+// Register makes a codec available under the provided name.
+// empty returns a codec ready to be filled by UnmarshalBinary when a backup is restored.
+// Register is meant to be called from the init function of the codec's package;
+// it panics if the name is already registered
+func Register(name string, empty func() Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic("codec: Register called twice for codec " + name)
+	}
+	registry[name] = empty
+}
+
+// Codecs returns the sorted list of the names of the registered codecs
+func Codecs() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Name returns the name under which the type of c is registered
+func Name(c Codec) (string, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for name, empty := range registry {
+		if reflect.TypeOf(empty()) == reflect.TypeOf(c) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("codec: unregistered codec %T", c)
+}
+
+// newCodec returns an empty codec registered under name
+func newCodec(name string) (Codec, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	empty, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("codec: unknown codec %q (forgotten import?)", name)
+	}
+	return empty(), nil
+}
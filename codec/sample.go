@@ -0,0 +1,65 @@
+package codec
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/owulveryck/min-char-rnn/rnn"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Sample returns a function suitable for rnn.Predict that picks an element
+// from a distribution and returns it 1-of-K encoded.
+// With the "soft" choice, the element is drawn from the distribution with rnd;
+// otherwise the most probable element is picked
+func Sample(choice string, rnd *rand.Rand) func([]float64) []float64 {
+	return func(p []float64) []float64 {
+		output := make([]float64, len(p))
+		switch choice {
+		case "soft":
+			sample := distuv.NewCategorical(p, rnd)
+			output[int(sample.Rand())] = 1
+		default:
+			best := float64(0)
+			bestIdx := 0
+			for i, v := range p {
+				if v > best {
+					best = v
+					bestIdx = i
+				}
+			}
+			output[bestIdx] = 1
+		}
+		return output
+	}
+}
+
+// Sampling holds the options of the generation shared by the codecs
+type Sampling struct {
+	// Choice is hard (pick the most probable element) or soft (draw from the distribution)
+	Choice string
+	// Penalties applied to the log-probability of the elements already emitted
+	FrequencyPenalty float64
+	PresencePenalty  float64
+	// PenaltyWindow is the number of elements taken into account for the penalties (0 means all)
+	PenaltyWindow int
+	// NoRepeatNgram is the size of the sequences of elements that cannot be repeated (0 disables the blocking)
+	NoRepeatNgram int
+}
+
+// Filters returns the repetition controls applied during the generation
+func (s Sampling) Filters() []rnn.Filter {
+	var filters []rnn.Filter
+	if s.FrequencyPenalty != 0 || s.PresencePenalty != 0 {
+		filters = append(filters, rnn.Penalize(s.FrequencyPenalty, s.PresencePenalty, s.PenaltyWindow))
+	}
+	if s.NoRepeatNgram > 0 {
+		filters = append(filters, rnn.BlockNgrams(s.NoRepeatNgram))
+	}
+	return filters
+}
+
+// ApplyDist picks an element from the distribution according to the choice
+func (s Sampling) ApplyDist(p []float64) []float64 {
+	return Sample(s.Choice, rand.New(rand.NewSource(time.Now().UnixNano())))(p)
+}
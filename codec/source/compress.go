@@ -0,0 +1,88 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// A bzip2 stream goes on with the magic of its first block, or of the end of the stream if it is empty
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// isBzip2 reports whether the content starting with magic is a bzip2 stream:
+// "BZh", the block size from '1' to '9' and the magic of the first block
+func isBzip2(magic []byte) bool {
+	n := len(bzip2Magic)
+	if len(magic) < n+1+len(bzip2BlockMagic) || !bytes.HasPrefix(magic, bzip2Magic) || magic[n] < '1' || magic[n] > '9' {
+		return false
+	}
+	block := magic[n+1 : n+1+len(bzip2BlockMagic)]
+	return bytes.Equal(block, bzip2BlockMagic) || bytes.Equal(block, bzip2EndMagic)
+}
+
+// decompressor reads the decompressed content and closes both the
+// decompressor and the compressed reader
+type decompressor struct {
+	io.Reader
+	closers []func() error
+}
+
+func (d *decompressor) Close() error {
+	var err error
+	for _, c := range d.closers {
+		if e := c(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// decompress detects gzip, bzip2 and zstd content by its magic bytes
+// and returns a reader that decompresses it while streaming.
+// Any other content is returned as is
+func decompress(rc io.ReadCloser) (io.ReadCloser, error) {
+	r := bufio.NewReader(rc)
+	magic, err := r.Peek(len(bzip2Magic) + 1 + len(bzip2BlockMagic))
+	if err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	d := &decompressor{
+		Reader:  r,
+		closers: []func() error{rc.Close},
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		z, err := gzip.NewReader(r)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		d.Reader = z
+		d.closers = append([]func() error{z.Close}, d.closers...)
+	case isBzip2(magic):
+		d.Reader = bzip2.NewReader(r)
+	case bytes.HasPrefix(magic, zstdMagic):
+		z, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		d.Reader = z
+		d.closers = append([]func() error{func() error {
+			z.Close()
+			return nil
+		}}, d.closers...)
+	}
+	return d, nil
+}
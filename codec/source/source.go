@@ -0,0 +1,221 @@
+// Package source reads the training corpus of the codecs epoch after epoch.
+// A corpus is made of sources that can be files, glob patterns, directory trees or any io.Reader.
+// The gzip, bzip2 and zstd contents are detected by their magic bytes and decompressed while
+// they are read: every epoch decompresses the sources again instead of keeping them in memory.
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Source is a part of the training corpus
+type Source struct {
+	// Path is a file, a glob pattern or a directory whose regular files are read recursively.
+	// It is ignored if Reader is set
+	Path string
+	// Reader is an arbitrary reader.
+	// If it is not seekable (such as a pipe), it is read once unless Cache is set
+	Reader io.Reader
+	// Cache keeps in memory the (compressed) content of a non-seekable Reader so it can be read at every epoch
+	Cache bool
+	// Weight is the number of times the source is read per epoch (1 if not set)
+	Weight int
+}
+
+// Files returns the sources made of the paths
+func Files(paths ...string) []Source {
+	sources := make([]Source, len(paths))
+	for i, p := range paths {
+		sources[i] = Source{Path: p}
+	}
+	return sources
+}
+
+// Corpus reads the sources epoch after epoch
+type Corpus struct {
+	sources []Source
+	// the files of every Path source
+	files [][]string
+	// the starting offset of the seekable readers; -1 if the reader is not seekable
+	offsets []int64
+	// the content of the cached readers
+	caches []*bytes.Buffer
+	// a non-seekable reader that has been read
+	consumed []bool
+}
+
+// NewCorpus resolves the paths of the sources and returns a Corpus
+func NewCorpus(sources ...Source) (*Corpus, error) {
+	c := &Corpus{
+		sources:  sources,
+		files:    make([][]string, len(sources)),
+		offsets:  make([]int64, len(sources)),
+		caches:   make([]*bytes.Buffer, len(sources)),
+		consumed: make([]bool, len(sources)),
+	}
+	for i, s := range sources {
+		if s.Reader != nil {
+			c.offsets[i] = -1
+			if seeker, ok := s.Reader.(io.Seeker); ok {
+				if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+					c.offsets[i] = offset
+				}
+			}
+			continue
+		}
+		files, err := resolve(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		c.files[i] = files
+	}
+	return c, nil
+}
+
+// resolve the path into a list of files
+func resolve(path string) ([]string, error) {
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("source: no file matches %v", path)
+	}
+	var files []string
+	for _, m := range matches {
+		err := filepath.Walk(m, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Next returns a reader on the whole corpus for the next epoch.
+// The caller must read it until the end and close it before calling Next again.
+// It returns io.EOF when there is nothing left to read, which happens when the
+// corpus is only made of non-seekable readers that have been consumed
+func (c *Corpus) Next() (io.ReadCloser, error) {
+	var parts []func() (io.ReadCloser, error)
+	for i, s := range c.sources {
+		weight := s.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		for w := 0; w < weight; w++ {
+			parts = append(parts, c.parts(i)...)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, io.EOF
+	}
+	return &sequence{parts: parts}, nil
+}
+
+// parts returns the functions that open the content of the i-th source
+func (c *Corpus) parts(i int) []func() (io.ReadCloser, error) {
+	s := c.sources[i]
+	if s.Reader == nil {
+		parts := make([]func() (io.ReadCloser, error), len(c.files[i]))
+		for j, f := range c.files[i] {
+			f := f
+			parts[j] = func() (io.ReadCloser, error) {
+				return os.Open(f)
+			}
+		}
+		return parts
+	}
+	switch {
+	case c.offsets[i] >= 0:
+		return []func() (io.ReadCloser, error){
+			func() (io.ReadCloser, error) {
+				_, err := s.Reader.(io.Seeker).Seek(c.offsets[i], io.SeekStart)
+				return ioutil.NopCloser(s.Reader), err
+			},
+		}
+	case c.caches[i] != nil:
+		return []func() (io.ReadCloser, error){
+			func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(c.caches[i].Bytes())), nil
+			},
+		}
+	case c.consumed[i]:
+		return nil
+	}
+	// First read of a non-seekable reader
+	c.consumed[i] = true
+	if s.Cache {
+		c.caches[i] = new(bytes.Buffer)
+		return []func() (io.ReadCloser, error){
+			func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(io.TeeReader(s.Reader, c.caches[i])), nil
+			},
+		}
+	}
+	return []func() (io.ReadCloser, error){
+		func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(s.Reader), nil
+		},
+	}
+}
+
+// sequence reads the parts one after the other; a part is opened when
+// the previous one is exhausted, so that a few files are opened at the same time
+type sequence struct {
+	parts   []func() (io.ReadCloser, error)
+	current io.ReadCloser
+}
+
+func (s *sequence) Read(p []byte) (int, error) {
+	for {
+		if s.current == nil {
+			if len(s.parts) == 0 {
+				return 0, io.EOF
+			}
+			r, err := s.parts[0]()
+			s.parts = s.parts[1:]
+			if err == nil {
+				r, err = decompress(r)
+			}
+			if err != nil {
+				return 0, err
+			}
+			s.current = r
+		}
+		n, err := s.current.Read(p)
+		if err == io.EOF {
+			err = s.current.Close()
+			s.current = nil
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close the part being read
+func (s *sequence) Close() error {
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
@@ -0,0 +1,136 @@
+package source
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// epochs reads n epochs of the corpus
+func epochs(t *testing.T, c *Corpus, n int) []string {
+	var contents []string
+	for i := 0; i < n; i++ {
+		r, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+		contents = append(contents, string(b))
+	}
+	return contents
+}
+
+func TestCorpus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"a.txt":     "a",
+		"b.txt":     "b",
+		"sub/c.txt": "c",
+		"sub/d.dat": "d",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seekable := strings.NewReader("s")
+	tests := []struct {
+		name    string
+		sources []Source
+		want    []string
+	}{
+		{"file", Files(filepath.Join(dir, "a.txt")), []string{"a", "a"}},
+		{"directory", Files(dir), []string{"abcd", "abcd"}},
+		{"glob", Files(filepath.Join(dir, "*", "*.txt")), []string{"c", "c"}},
+		{"weight", []Source{{Path: filepath.Join(dir, "a.txt"), Weight: 2}, {Path: filepath.Join(dir, "b.txt")}}, []string{"aab", "aab"}},
+		{"seekable", []Source{{Reader: seekable, Weight: 2}}, []string{"ss", "ss"}},
+		{"stream", []Source{{Reader: ioutil.NopCloser(strings.NewReader("r"))}}, []string{"r"}},
+		{"cache", []Source{{Reader: ioutil.NopCloser(strings.NewReader("r")), Cache: true, Weight: 2}}, []string{"rr", "rr"}},
+		{"mixed", []Source{{Reader: ioutil.NopCloser(strings.NewReader("r"))}, {Path: filepath.Join(dir, "b.txt")}}, []string{"rb", "b"}},
+	}
+	for _, test := range tests {
+		c, err := NewCorpus(test.sources...)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		got := epochs(t, c, 2)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%v: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+	if _, err := NewCorpus(Files(filepath.Join(dir, "missing*"))...); err == nil {
+		t.Error("expected an error for a pattern without match")
+	}
+}
+
+// hello bzip2\n compressed with bzip2
+var bzip2Hello = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xab, 0x6b, 0xa1, 0xf1, 0x00, 0x00,
+	0x02, 0xd9, 0x80, 0x00, 0x10, 0x40, 0x00, 0x10, 0x00, 0x12, 0x64, 0xc0, 0x10, 0x20, 0x00, 0x31,
+	0x00, 0xd3, 0x4d, 0x04, 0x00, 0x1e, 0xa3, 0xef, 0x4e, 0x51, 0xa2, 0x07, 0x8b, 0xb9, 0x22, 0x9c,
+	0x28, 0x48, 0x55, 0xb5, 0xd0, 0xf8, 0x80,
+}
+
+func TestDecompress(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("hello gzip\n"))
+	w.Close()
+	var zst bytes.Buffer
+	z, err := zstd.NewWriter(&zst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.Write([]byte("hello zstd\n"))
+	z.Close()
+	sources := []Source{
+		{Reader: bytes.NewReader(gz.Bytes())},
+		{Reader: ioutil.NopCloser(bytes.NewReader(bzip2Hello)), Cache: true},
+		{Reader: bytes.NewReader(zst.Bytes())},
+		{Reader: strings.NewReader("hello\n")},
+	}
+	c, err := NewCorpus(sources...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "hello gzip\nhello bzip2\nhello zstd\nhello\n"
+	for _, got := range epochs(t, c, 2) {
+		if got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestPlainBZh(t *testing.T) {
+	// Texts that start like a bzip2 stream
+	for _, text := range []string{"BZh", "BZhello\n", "BZh9 is not compressed\n", "BZh91AY&SX is not either\n"} {
+		c, err := NewCorpus(Source{Reader: strings.NewReader(text)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := epochs(t, c, 1); len(got) != 1 || got[0] != text {
+			t.Errorf("expected %q, got %q", text, got)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package codec
+
+import (
+	"io"
+	"log"
+	"math/rand"
+
+	"github.com/owulveryck/min-char-rnn/rnn"
+)
+
+// Windowing holds the options that cut the training corpus into
+// windows of BatchSize+1 elements, shared by the codecs.
+// By default, the corpus is read sequentially in non-overlapping windows
+// and the hidden state is carried from a training set to the next one
+type Windowing struct {
+	// Stride is the distance between the starts of two consecutive windows;
+	// 0 means BatchSize+1 (no overlap). A smaller stride gives overlapping windows
+	Stride int
+	// Shuffle the order of the windows at every epoch
+	Shuffle bool
+	// ShuffleBuffer is the number of windows shuffled together;
+	// 0 shuffles the whole epoch, which is then held in memory
+	ShuffleBuffer int
+	// RandomOffset starts every epoch at a random offset lower than the stride
+	RandomOffset bool
+	// Seed of the shuffling and of the offsets
+	Seed int64
+	// Streams is the number of sequences of a mini-batch (1 if not set).
+	// Without shuffling, the streams read the corpus by blocks of Streams*StreamLength windows,
+	// every stream reading its own StreamLength consecutive windows of the block so that its hidden
+	// state is carried; only the current block is held in memory
+	Streams int
+	// StreamLength is the number of consecutive windows a stream reads in a block (1000 if not set).
+	// At the end of an epoch, the last block is shared evenly between the streams
+	StreamLength int
+}
+
+type window struct {
+	// position of the first element in the epoch
+	start    int
+	elements []int
+}
+
+// Feed returns a channel filled with the TrainingSets made of the windows of the corpus.
+// epoch is called once per epoch: it reads the corpus and calls emit with the index of every element;
+// it returns io.EOF when there is nothing left to read.
+// The TrainingSets hold the indexes of the elements (the one-hot vectors are never materialized)
+// and are marked for a reset of the hidden state when they do not follow the previous one.
+// With several streams, the windows left over at the end of an epoch are sent in a last mini-batch
+// of fewer streams
+func (w Windowing) Feed(batchSize, epochs int, epoch func(emit func(int)) error) <-chan rnn.TrainingSet {
+	feed := make(chan rnn.TrainingSet, 1)
+	go func(feed chan<- rnn.TrainingSet) {
+		size := batchSize + 1
+		stride := w.Stride
+		if stride <= 0 {
+			stride = size
+		}
+		streams := w.Streams
+		if streams < 1 {
+			streams = 1
+		}
+		length := w.StreamLength
+		if length <= 0 {
+			length = 1000
+		}
+		rnd := rand.New(rand.NewSource(w.Seed))
+		for e := 0; e < epochs; e++ {
+			// The start of the last window of every stream.
+			// The sequential reading carries the hidden state from an epoch to the next one
+			last := make([]int, streams)
+			for i := range last {
+				last[i] = -1
+			}
+			carry := !w.Shuffle && !w.RandomOffset
+			trainingSet := func(stream int, win window) rnn.TrainingSet {
+				reset := !carry
+				if last[stream] >= 0 {
+					d := win.start - last[stream]
+					reset = d != batchSize && d != size
+				}
+				last[stream] = win.start
+				return rnn.TrainingSet{
+					InputIndexes:  win.elements[:batchSize],
+					TargetIndexes: win.elements[1:],
+					Reset:         reset,
+				}
+			}
+			var batch []rnn.TrainingSet
+			send := func(win window) {
+				if streams == 1 {
+					feed <- trainingSet(0, win)
+					return
+				}
+				batch = append(batch, trainingSet(len(batch), win))
+				if len(batch) == streams {
+					feed <- rnn.TrainingSet{Streams: batch}
+					batch = nil
+				}
+			}
+			// split sends a block of windows, every stream reading a consecutive part of it
+			split := func(block []window) {
+				n := len(block) / streams
+				for i := 0; i < n; i++ {
+					for stream := 0; stream < streams; stream++ {
+						send(block[stream*n+i])
+					}
+				}
+				for _, win := range block[streams*n:] {
+					send(win)
+				}
+			}
+			var pending []window
+			push := func(win window) {
+				switch {
+				case w.Shuffle:
+					pending = append(pending, win)
+					if w.ShuffleBuffer > 0 && len(pending) >= w.ShuffleBuffer {
+						i := rnd.Intn(len(pending))
+						send(pending[i])
+						pending[i] = pending[len(pending)-1]
+						pending = pending[:len(pending)-1]
+					}
+				case streams == 1:
+					send(win)
+				default:
+					pending = append(pending, win)
+					if len(pending) == streams*length {
+						split(pending)
+						pending = pending[:0]
+					}
+				}
+			}
+			next := 0
+			if w.RandomOffset {
+				next = rnd.Intn(stride)
+			}
+			pos := 0
+			buf := make([]int, 0, size)
+			err := epoch(func(ix int) {
+				if pos >= next {
+					buf = append(buf, ix)
+				}
+				pos++
+				if len(buf) == size {
+					push(window{next, append([]int(nil), buf...)})
+					next += stride
+					if stride < size {
+						buf = append(buf[:0], buf[stride:]...)
+					} else {
+						buf = buf[:0]
+					}
+				}
+			})
+			if err == io.EOF {
+				// Nothing left to read
+				break
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			if w.Shuffle {
+				rnd.Shuffle(len(pending), func(i, j int) {
+					pending[i], pending[j] = pending[j], pending[i]
+				})
+				for _, win := range pending {
+					send(win)
+				}
+			} else {
+				split(pending)
+			}
+			// The windows left over
+			if len(batch) > 0 {
+				feed <- rnn.TrainingSet{Streams: batch}
+			}
+		}
+		close(feed)
+	}(feed)
+	return feed
+}
@@ -0,0 +1,117 @@
+package word
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const unknown = "<unk>"
+
+// scanTokens is a bufio.SplitFunc that returns the words, the punctuation signs
+// and the new lines of the input; the other spaces are skipped.
+// A word is a sequence of letters and digits, possibly with inner apostrophes (don't)
+func scanTokens(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// Skip the spaces but the new lines
+	start := 0
+	for start < len(data) {
+		r, size := utf8.DecodeRune(data[start:])
+		if r == '\n' || !unicode.IsSpace(r) {
+			break
+		}
+		start += size
+	}
+	if start == len(data) || !atEOF && !utf8.FullRune(data[start:]) {
+		// Request more data
+		return start, nil, nil
+	}
+	r, size := utf8.DecodeRune(data[start:])
+	if !isWordRune(r) {
+		return start + size, data[start : start+size], nil
+	}
+	for i := start + size; i < len(data); i += size {
+		if !atEOF && !utf8.FullRune(data[i:]) {
+			return start, nil, nil
+		}
+		r, size = utf8.DecodeRune(data[i:])
+		if r == '\'' {
+			// The apostrophe belongs to the word if it is followed by a letter
+			if !atEOF && !utf8.FullRune(data[i+size:]) {
+				return start, nil, nil
+			}
+			if next, _ := utf8.DecodeRune(data[i+size:]); isWordRune(next) {
+				continue
+			}
+		}
+		if !isWordRune(r) {
+			return i, data[start:i], nil
+		}
+	}
+	if atEOF {
+		return len(data), data[start:], nil
+	}
+	// Request more data
+	return start, nil, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokenize the reader
+func tokenize(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanTokens)
+	var tokens []string
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Text())
+	}
+	return tokens, scanner.Err()
+}
+
+// getVocab reads all the input and returns the size-1 most frequent tokens
+// preceded by the unknown token
+func getVocab(r io.Reader, size int) ([]string, error) {
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanTokens)
+	for scanner.Scan() {
+		counts[scanner.Text()]++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	delete(counts, unknown)
+	words := make([]string, 0, len(counts))
+	for w := range counts {
+		words = append(words, w)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if counts[words[i]] != counts[words[j]] {
+			return counts[words[i]] > counts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	if len(words) > size-1 {
+		words = words[:size-1]
+	}
+	return append([]string{unknown}, words...), nil
+}
+
+// join the tokens back into a text: the words are separated by a space,
+// except around new lines, before closing punctuation and after opening punctuation
+func join(tokens []string) string {
+	var sb strings.Builder
+	prev := "\n"
+	for _, t := range tokens {
+		if prev != "\n" && t != "\n" && !strings.ContainsAny(prev, "([{") && !(len(t) == 1 && strings.ContainsAny(t, ".,;:!?)]}'")) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(t)
+		prev = t
+	}
+	return sb.String()
+}
@@ -0,0 +1,262 @@
+package word
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/owulveryck/min-char-rnn/codec"
+	"github.com/owulveryck/min-char-rnn/codec/source"
+	"github.com/owulveryck/min-char-rnn/rnn"
+)
+
+// Options of the word codec
+type Options struct {
+	// Sources are the parts of the training corpus, read in sequence at every epoch.
+	// The vocabulary is built from a first reading: the non-seekable readers are always cached
+	Sources []source.Source
+	// VocabSize is the number of words of the vocabulary, including the unknown token
+	VocabSize int
+	// BatchSize is the length of the sequences of the training sets
+	BatchSize int
+	// Epoch is the number of times the training corpus is read
+	Epoch int
+	codec.Windowing
+	codec.Sampling
+}
+
+// Word is a codec that feeds a RNN with the words and the punctuation signs of a text.
+// Its vocabulary is made of the most frequent words of the training corpus
+// and of an unknown token that stands for all the others
+type Word struct {
+	loss       float64
+	smoothLoss float64
+	wordsToIx  map[string]int
+	ixToWords  []string
+	opts       Options
+	corpus     *source.Corpus
+}
+
+func init() {
+	gob.Register(&Word{})
+	codec.Register("word", func() codec.Codec {
+		return &Word{}
+	})
+}
+
+// New creates a word codec whose vocabulary is built from the training corpus
+func New(opts Options) (*Word, error) {
+	if opts.VocabSize < 2 {
+		return nil, errors.New("VocabSize must be at least 2")
+	}
+	if len(opts.Sources) == 0 {
+		return nil, errors.New("The vocabulary is built from the Sources")
+	}
+	w := &Word{}
+	err := w.SetOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	var words []string
+	err = w.readCorpus(func(r io.Reader) error {
+		var err error
+		words, err = getVocab(r, opts.VocabSize)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.setVocab(words)
+	w.smoothLoss = -math.Log(float64(1)/float64(len(words))) * float64(opts.BatchSize)
+	return w, nil
+}
+
+// SetOptions replaces the options of the codec; the vocabulary is left untouched.
+// It is used to configure a restored codec for a new training
+func (w *Word) SetOptions(opts Options) error {
+	if opts.BatchSize <= 0 {
+		return errors.New("BatchSize must be positive")
+	}
+	sources := make([]source.Source, len(opts.Sources))
+	for i, s := range opts.Sources {
+		s.Cache = true
+		sources[i] = s
+	}
+	corpus, err := source.NewCorpus(sources...)
+	if err != nil {
+		return err
+	}
+	w.opts = opts
+	w.corpus = corpus
+	return nil
+}
+
+// SetSampling replaces the options of the generation
+func (w *Word) SetSampling(s codec.Sampling) {
+	w.opts.Sampling = s
+}
+
+// readCorpus calls f with the content of the corpus for the next epoch
+func (w *Word) readCorpus(f func(io.Reader) error) error {
+	r, err := w.corpus.Next()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return f(r)
+}
+
+func (w *Word) setVocab(words []string) {
+	w.ixToWords = words
+	w.wordsToIx = make(map[string]int, len(words))
+	for i, word := range words {
+		w.wordsToIx[word] = i
+	}
+}
+
+// oneOfK returns the 1-of-K encoded vector of the word of index ix
+func (w *Word) oneOfK(ix int) []float64 {
+	oneOfK := make([]float64, len(w.ixToWords))
+	oneOfK[ix] = 1
+	return oneOfK
+}
+
+// Decode an array of inputs and returns an io.Reader
+// the input is an array of 1-of-K encoded vectors
+func (w *Word) Decode(xs [][]float64) io.Reader {
+	tokens := make([]string, len(xs))
+	for i, x := range xs {
+		idx := 0
+		for idx = range x {
+			if x[idx] == 1 {
+				break
+			}
+		}
+		tokens[i] = w.ixToWords[idx]
+	}
+	return strings.NewReader(join(tokens))
+}
+
+// Encode the io.Reader into an slice composed of
+// 1-of-K encoded vectors; the words out of the vocabulary
+// are encoded as the unknown token
+func (w *Word) Encode(r io.Reader) [][]float64 {
+	tokens, err := tokenize(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	xs := make([][]float64, len(tokens))
+	for i, t := range tokens {
+		xs[i] = w.oneOfK(w.wordsToIx[t])
+	}
+	return xs
+}
+
+// Feed returns a channel that will be filled with TrainingSets
+// its triggers a go-routine that reads the inputs and
+// that is putting some data in the channel
+func (w *Word) Feed() <-chan rnn.TrainingSet {
+	return w.opts.Windowing.Feed(w.opts.BatchSize, w.opts.Epoch, func(emit func(int)) error {
+		return w.readCorpus(func(r io.Reader) error {
+			scanner := bufio.NewScanner(r)
+			scanner.Split(scanTokens)
+			for scanner.Scan() {
+				emit(w.wordsToIx[scanner.Text()])
+			}
+			return scanner.Err()
+		})
+	})
+}
+
+// NewRNN returns a neural network suitable for this codec
+func (w *Word) NewRNN() *rnn.RNN {
+	return rnn.NewRNN(len(w.ixToWords), len(w.ixToWords))
+}
+
+// ApplyDist applies  a distribution according to the configuration of the neural network
+func (w *Word) ApplyDist(p []float64) []float64 {
+	return w.opts.ApplyDist(p)
+}
+
+// Filters returns the repetition controls applied during the generation
+func (w *Word) Filters() []rnn.Filter {
+	return w.opts.Filters()
+}
+
+// Allow returns a filter that restricts the generation to the words
+// made of allowed runes
+func (w *Word) Allow(allowed func(rune) bool) rnn.Filter {
+	return rnn.Constrain(func(ix int, _ [][]float64) bool {
+		if ix >= len(w.ixToWords) || ix == w.wordsToIx[unknown] {
+			return false
+		}
+		for _, r := range w.ixToWords[ix] {
+			if !allowed(r) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// SetLoss sets the loss and the smoothLoss
+func (w *Word) SetLoss(loss float64) {
+	w.loss = loss
+	w.smoothLoss = w.smoothLoss*0.999 + loss*0.001
+}
+
+// Infos ...
+type Infos struct {
+	SmoothLoss float64
+}
+
+// MarshalJSON ...
+func (i Infos) MarshalJSON() ([]byte, error) {
+	type infos Infos
+	return json.Marshal(infos(i))
+}
+
+// GetInfos ...
+func (w *Word) GetInfos() json.Marshaler {
+	return Infos{
+		w.smoothLoss,
+	}
+}
+
+type backupStruct struct {
+	Loss       float64
+	SmoothLoss float64
+	Words      []string
+	BatchSize  int
+}
+
+// MarshalBinary ...
+func (w *Word) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := gob.NewEncoder(buf)
+	err := enc.Encode(backupStruct{
+		Loss:       w.loss,
+		SmoothLoss: w.smoothLoss,
+		Words:      w.ixToWords,
+		BatchSize:  w.opts.BatchSize,
+	})
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary ...
+func (w *Word) UnmarshalBinary(b []byte) error {
+	var t backupStruct
+	dec := gob.NewDecoder(bytes.NewBuffer(b))
+	err := dec.Decode(&t)
+	w.loss = t.Loss
+	w.smoothLoss = t.SmoothLoss
+	w.opts.BatchSize = t.BatchSize
+	w.setVocab(t.Words)
+	return err
+}
@@ -0,0 +1,31 @@
+package word
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	text := "First Citizen:\nWe are accounted poor citizens, the patricians good. I don't know (yet)!\n"
+	tokens, err := tokenize(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"First", "Citizen", ":", "\n", "We", "are", "accounted", "poor", "citizens", ",", "the", "patricians", "good", ".", "I", "don't", "know", "(", "yet", ")", "!", "\n"}
+	if strings.Join(tokens, "|") != strings.Join(expected, "|") {
+		t.Fatalf("bad tokens: %q", tokens)
+	}
+	if join(tokens) != text {
+		t.Fatalf("bad spacing: %q", join(tokens))
+	}
+}
+
+func TestGetVocab(t *testing.T) {
+	words, err := getVocab(strings.NewReader("b a b c b a"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(words, " ") != "<unk> b a" {
+		t.Fatalf("bad vocabulary: %v", words)
+	}
+}
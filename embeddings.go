@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/owulveryck/min-char-rnn/codec"
+	"github.com/owulveryck/min-char-rnn/rnn"
+)
+
+// exportEmbeddings writes the learned embedding of every input element as tab-separated values,
+// one element per line, and the labels of the elements in the same order.
+// It is the format of the TensorFlow embedding projector
+func exportEmbeddings(cdc codec.Codec, nn *rnn.RNN, vectors, metadata io.Writer) error {
+	embeddings := nn.Embeddings()
+	if embeddings == nil {
+		return errors.New("The model has no embedding layer (see RNN_EMBEDDINGSIZE)")
+	}
+	v := bufio.NewWriter(vectors)
+	m := bufio.NewWriter(metadata)
+	for ix, e := range embeddings {
+		x := make([]float64, len(embeddings))
+		x[ix] = 1
+		b, err := ioutil.ReadAll(cdc.Decode([][]float64{x}))
+		if err != nil {
+			return err
+		}
+		// The newlines, tabs and invalid UTF-8 sequences are escaped
+		label := strconv.Quote(string(b))
+		m.WriteString(label[1:len(label)-1] + "\n")
+		fields := make([]string, len(e))
+		for i, f := range e {
+			fields[i] = strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		v.WriteString(strings.Join(fields, "\t") + "\n")
+	}
+	if err := v.Flush(); err != nil {
+		return err
+	}
+	return m.Flush()
+}
+
+// exportEmbeddingFiles writes the embeddings to prefix.tsv and the labels to prefix_metadata.tsv
+func exportEmbeddingFiles(cdc codec.Codec, nn *rnn.RNN, prefix string) error {
+	vectors, err := os.Create(prefix + ".tsv")
+	if err != nil {
+		return err
+	}
+	defer vectors.Close()
+	metadata, err := os.Create(prefix + "_metadata.tsv")
+	if err != nil {
+		return err
+	}
+	defer metadata.Close()
+	err = exportEmbeddings(cdc, nn, vectors, metadata)
+	if err != nil {
+		return err
+	}
+	if err := vectors.Close(); err != nil {
+		return err
+	}
+	return metadata.Close()
+}
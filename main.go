@@ -2,17 +2,19 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"flag"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"runtime"
+	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/owulveryck/min-char-rnn/codec"
-	"github.com/owulveryck/min-char-rnn/codec/char"
 	"github.com/owulveryck/min-char-rnn/rnn"
 )
 
@@ -25,12 +27,15 @@ type configuration struct {
 	BackupPrefix string `default:""`
 	// Backup Suffix, should be compatible with time.Format()
 	BackupSuffix string `default:""`
+	// Codec used to train a new model: char, word, bpe or bytes
+	Codec string `default:"char"`
 }
 
 var (
 	conf        configuration
 	restoreFile *string
 	backupFile  *string
+	allowed     *string
 )
 
 func usage(err error) error {
@@ -48,7 +53,17 @@ func main() {
 	//vocab := flag.String("vocab", "data/vocab.txt", "the file holds the vocabulary")
 	//input := flag.String("input", "data/input.txt", "the input text to train the network")
 	train := flag.Bool("train", false, "Training a rnn")
+	eval := flag.Bool("eval", false, "Evaluate the log-likelihood and the perplexity of the text read from stdin")
+	prefix := flag.String("prefix", "", "Text that conditions the rnn before the evaluation")
+	detect := flag.Bool("anomaly", false, "Read stdin line by line and report the surprising lines as JSON")
+	threshold := flag.Float64("threshold", 4, "Average surprise (in nats per element) above which a line or a span is reported")
+	window := flag.Int("window", 10, "Number of elements of the sliding window used to locate surprising spans (0 to disable)")
+	jobs := flag.String("batch", "", "JSON lines file describing the samples to generate (- for stdin)")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of concurrent generations in batch mode")
+	embeddings := flag.String("embeddings", "", "Export the learned embeddings of the restored model to <prefix>.tsv and <prefix>_metadata.tsv")
+	convert := flag.Int("convert", 0, "Convert the restored model to this precision (32 or 64 bits) and back it up")
 	restoreFile = flag.String("restore", "", "backup file to restoreFile")
+	allowed = flag.String("allow", "", "If set, the generation is restricted to these characters")
 	//endRegexp := flag.String("sampleEndRegexp", "", "If ca generated char match the regexp, it stops")
 	help := flag.Bool("h", false, "display help")
 	flag.Parse()
@@ -60,15 +75,15 @@ func main() {
 		log.Println(usage(err))
 		log.Fatal(err)
 	}
-	switch *train {
-	case true:
+	switch {
+	case *train:
 		//training(vocab, input, start, endRegexp, restoreFile, backup, num)
 		var cdc codec.Codec
 		var nn *rnn.RNN
-		cdc, nn, err = restore()
+		cdc, nn, err = restore(true)
 		if err != nil {
 			log.Println("Cannot restore from backup, creating new entries", err)
-			cdc, err = char.NewChar()
+			cdc, err = newCodec()
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -78,7 +93,8 @@ func main() {
 		feeder := cdc.Feed()
 		// Create the sampling
 		var sample [][]float64
-		if conf.SampleFrequency != 0 {
+		// The standard input holds the start of the samples, unless it is part of the training corpus
+		if conf.SampleFrequency != 0 && !stdinInput {
 			b, err := ioutil.ReadAll(os.Stdin)
 			if err != nil {
 				log.Println("No start provided, sampling will be done ", err)
@@ -86,6 +102,10 @@ func main() {
 				sample = cdc.Encode(bytes.NewBuffer(b))
 			}
 		}
+		filters, err := sampleFilters(cdc)
+		if err != nil {
+			log.Fatal(err)
+		}
 		n := 0
 		for tset := range feeder {
 			feed <- rnn.CopyOf(tset)
@@ -104,30 +124,104 @@ func main() {
 				}
 			}
 			if n%conf.SampleFrequency == 0 && n != 0 && conf.SampleFrequency != 0 && len(sample) > 0 {
-				ys := nn.Predict(sample, conf.SampleSize, cdc.ApplyDist)
+				ys := nn.Predict(sample, conf.SampleSize, cdc.ApplyDist, filters...)
 				io.Copy(os.Stdout, cdc.Decode(ys))
 			}
 			n++
 		}
 		log.Println("end")
 		close(feed)
+		// Wait for the end of the training
+		for range info {
+		}
 		err = backup(cdc, nn)
 		if err != nil {
 			log.Println("Cannot backup ", err)
 		}
-	case false:
-		cdc, nn, err := restore()
+	case *eval:
+		cdc, nn, err := restore(false)
+		if err != nil {
+			log.Fatal("Unable to restore ", err)
+		}
+		var p io.Reader
+		if *prefix != "" {
+			p = strings.NewReader(*prefix)
+		}
+		ev := codec.Evaluate(cdc, nn, p, os.Stdin)
+		err = json.NewEncoder(os.Stdout).Encode(ev)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case *jobs != "":
+		cdc, nn, err := restore(false)
+		if err != nil {
+			log.Fatal("Unable to restore ", err)
+		}
+		var r io.Reader = os.Stdin
+		if *jobs != "-" {
+			f, err := os.Open(*jobs)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			r = f
+		}
+		err = generateBatch(cdc, nn, r, os.Stdout, *workers)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case *embeddings != "":
+		cdc, nn, err := restore(false)
+		if err != nil {
+			log.Fatal("Unable to restore ", err)
+		}
+		err = exportEmbeddingFiles(cdc, nn, *embeddings)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case *convert != 0:
+		if conf.BackupPrefix == "" {
+			log.Fatal("MIN_CHAR_BACKUPPREFIX is needed to save the converted model")
+		}
+		cdc, nn, err := restore(false)
+		if err != nil {
+			log.Fatal("Unable to restore ", err)
+		}
+		nn, err = nn.Convert(*convert)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = backup(cdc, nn)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case *detect:
+		cdc, nn, err := restore(false)
+		if err != nil {
+			log.Fatal("Unable to restore ", err)
+		}
+		err = detectAnomalies(cdc, nn, os.Stdin, os.Stdout, *threshold, *window)
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		cdc, nn, err := restore(false)
 		if err != nil {
 			log.Fatal("Unable to restore ", err)
 		}
 		xs := cdc.Encode(os.Stdin)
-		log.Println(len(xs))
-
-		ys := nn.Predict(xs, conf.SampleSize, cdc.ApplyDist)
+		filters, err := sampleFilters(cdc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ys := nn.Predict(xs, conf.SampleSize, cdc.ApplyDist, filters...)
 		io.Copy(os.Stdout, cdc.Decode(ys))
 	}
 }
-func restore() (codec.Codec, *rnn.RNN, error) {
+
+// restore the codec and the RNN from the backup file and configure the codec;
+// the training options are read only if the model is restored for a training
+func restore(training bool) (codec.Codec, *rnn.RNN, error) {
 	if *restoreFile == "" {
 		return nil, nil, errors.New("No restore file specified")
 	}
@@ -135,11 +229,31 @@ func restore() (codec.Codec, *rnn.RNN, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	cdcb, nn, err := codec.Restore(b)
-	cdc := &char.Char{}
-	cdc.UnmarshalBinary(cdcb)
-	return cdc, nn, err
+	cdc, nn, err := codec.Restore(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cdc, nn, configureCodec(cdc, training)
 }
+
+// sampleFilters returns the filters to apply to the distributions during the generation
+func sampleFilters(cdc codec.Codec) ([]rnn.Filter, error) {
+	var filters []rnn.Filter
+	if f, ok := cdc.(codec.Filterer); ok {
+		filters = append(filters, f.Filters()...)
+	}
+	if *allowed != "" {
+		c, ok := cdc.(codec.Constrainer)
+		if !ok {
+			return nil, errors.New("The codec cannot restrict the generation")
+		}
+		filters = append(filters, c.Allow(func(r rune) bool {
+			return strings.ContainsRune(*allowed, r)
+		}))
+	}
+	return filters, nil
+}
+
 func backup(cdc codec.Codec, rnn *rnn.RNN) error {
 	if conf.BackupPrefix != "" {
 		b, err := codec.Save(cdc, rnn)
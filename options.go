@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/owulveryck/min-char-rnn/codec"
+	"github.com/owulveryck/min-char-rnn/codec/bpe"
+	bytecodec "github.com/owulveryck/min-char-rnn/codec/bytes"
+	"github.com/owulveryck/min-char-rnn/codec/char"
+	"github.com/owulveryck/min-char-rnn/codec/source"
+	"github.com/owulveryck/min-char-rnn/codec/word"
+)
+
+// The environment variables of a codec are prefixed by the upper-cased name of the codec
+// followed by _CODEC (CHAR_CODEC_INPUT_FILE, WORD_CODEC_VOCAB_SIZE, ...)
+func envPrefix(name string) string {
+	return map[string]string{
+		"char":  "CHAR_CODEC",
+		"word":  "WORD_CODEC",
+		"bpe":   "BPE_CODEC",
+		"bytes": "BYTES_CODEC",
+	}[name]
+}
+
+type samplingConfiguration struct {
+	Choice string `default:"hard" required:"true"`
+	// Penalties applied to the log-probability of the elements already emitted
+	FrequencyPenalty float64 `envconfig:"FREQUENCY_PENALTY" default:"0"`
+	PresencePenalty  float64 `envconfig:"PRESENCE_PENALTY" default:"0"`
+	// Number of elements taken into account for the penalties (0 means all)
+	PenaltyWindow int `envconfig:"PENALTY_WINDOW" default:"0"`
+	// Size of the sequences of elements that cannot be repeated (0 disables the blocking)
+	NoRepeatNgram int `envconfig:"NO_REPEAT_NGRAM" default:"0"`
+}
+
+type windowingConfiguration struct {
+	// Distance between the starts of two windows (0 means BATCH_SIZE+1)
+	Stride        int   `default:"0"`
+	Shuffle       bool  `default:"false"`
+	ShuffleBuffer int   `envconfig:"SHUFFLE_BUFFER" default:"0"`
+	RandomOffset  bool  `envconfig:"RANDOM_OFFSET" default:"false"`
+	Seed          int64 `default:"0"`
+	// Number of sequences trained together in a mini-batch
+	Streams int `default:"1"`
+	// Number of consecutive windows read by a stream in a block of the corpus
+	StreamLength int `envconfig:"STREAM_LENGTH" default:"1000"`
+}
+
+type charConfiguration struct {
+	Epoch     int    `default:"100" required:"true"`
+	Input     string `envconfig:"input_file" default:"" required:"true"`
+	Cache     bool   `envconfig:"CACHE_INPUT" default:"false"`
+	VocabFile string `envconfig:"vocab_file" default:"" required:"true"`
+	BatchSize int    `envconfig:"BATCH_SIZE" default:"25" required:"true"`
+}
+
+type wordConfiguration struct {
+	Epoch     int    `default:"100" required:"true"`
+	Input     string `envconfig:"input_file" default:"" required:"true"`
+	Cache     bool   `envconfig:"CACHE_INPUT" default:"false"`
+	VocabSize int    `envconfig:"VOCAB_SIZE" default:"10000" required:"true"`
+	BatchSize int    `envconfig:"BATCH_SIZE" default:"25" required:"true"`
+}
+
+type bpeConfiguration struct {
+	Epoch     int    `default:"100" required:"true"`
+	Input     string `envconfig:"input_file" default:"" required:"true"`
+	Cache     bool   `envconfig:"CACHE_INPUT" default:"false"`
+	VocabSize int    `envconfig:"VOCAB_SIZE" default:"1000" required:"true"`
+	BatchSize int    `envconfig:"BATCH_SIZE" default:"25" required:"true"`
+}
+
+type bytesConfiguration struct {
+	Epoch     int    `default:"100" required:"true"`
+	Input     string `envconfig:"input_file" default:"" required:"true"`
+	Cache     bool   `envconfig:"CACHE_INPUT" default:"false"`
+	BatchSize int    `envconfig:"BATCH_SIZE" default:"25" required:"true"`
+}
+
+// stdinInput is set when the training corpus is read from the standard input
+var stdinInput bool
+
+// parseSources splits the comma-separated list of inputs into sources.
+// An input is a file, a glob or a directory, optionally followed by @n to read it n times per epoch;
+// - is the standard input, which is read once unless cache is set (or stdin is a regular file)
+func parseSources(inputs string, cache bool) ([]source.Source, error) {
+	var sources []source.Source
+	for _, input := range strings.Split(inputs, ",") {
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+		var s source.Source
+		if i := strings.LastIndex(input, "@"); i >= 0 {
+			if w, err := strconv.Atoi(input[i+1:]); err == nil {
+				if w <= 0 {
+					return nil, fmt.Errorf("The weight of %v must be positive", input)
+				}
+				s.Weight = w
+				input = input[:i]
+			}
+		}
+		if input == "-" {
+			s.Reader = os.Stdin
+			s.Cache = cache
+			stdinInput = true
+		} else {
+			s.Path = input
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
+
+func windowingOptions(name string) (codec.Windowing, error) {
+	var w windowingConfiguration
+	err := envconfig.Process(envPrefix(name), &w)
+	return codec.Windowing(w), err
+}
+
+func samplingOptions(name string) (codec.Sampling, error) {
+	var s samplingConfiguration
+	err := envconfig.Process(envPrefix(name), &s)
+	return codec.Sampling(s), err
+}
+
+// charOptions reads the options of the char codec from the environment
+func charOptions() (char.Options, error) {
+	var c charConfiguration
+	err := envconfig.Process(envPrefix("char"), &c)
+	if err != nil {
+		return char.Options{}, err
+	}
+	sources, err := parseSources(c.Input, c.Cache)
+	if err != nil {
+		return char.Options{}, err
+	}
+	w, err := windowingOptions("char")
+	if err != nil {
+		return char.Options{}, err
+	}
+	s, err := samplingOptions("char")
+	return char.Options{
+		Sources:   sources,
+		VocabFile: c.VocabFile,
+		BatchSize: c.BatchSize,
+		Epoch:     c.Epoch,
+		Windowing: w,
+		Sampling:  s,
+	}, err
+}
+
+// wordOptions reads the options of the word codec from the environment
+func wordOptions() (word.Options, error) {
+	var c wordConfiguration
+	err := envconfig.Process(envPrefix("word"), &c)
+	if err != nil {
+		return word.Options{}, err
+	}
+	sources, err := parseSources(c.Input, c.Cache)
+	if err != nil {
+		return word.Options{}, err
+	}
+	w, err := windowingOptions("word")
+	if err != nil {
+		return word.Options{}, err
+	}
+	s, err := samplingOptions("word")
+	return word.Options{
+		Sources:   sources,
+		VocabSize: c.VocabSize,
+		BatchSize: c.BatchSize,
+		Epoch:     c.Epoch,
+		Windowing: w,
+		Sampling:  s,
+	}, err
+}
+
+// bpeOptions reads the options of the BPE codec from the environment
+func bpeOptions() (bpe.Options, error) {
+	var c bpeConfiguration
+	err := envconfig.Process(envPrefix("bpe"), &c)
+	if err != nil {
+		return bpe.Options{}, err
+	}
+	sources, err := parseSources(c.Input, c.Cache)
+	if err != nil {
+		return bpe.Options{}, err
+	}
+	w, err := windowingOptions("bpe")
+	if err != nil {
+		return bpe.Options{}, err
+	}
+	s, err := samplingOptions("bpe")
+	return bpe.Options{
+		Sources:   sources,
+		VocabSize: c.VocabSize,
+		BatchSize: c.BatchSize,
+		Epoch:     c.Epoch,
+		Windowing: w,
+		Sampling:  s,
+	}, err
+}
+
+// bytesOptions reads the options of the bytes codec from the environment
+func bytesOptions() (bytecodec.Options, error) {
+	var c bytesConfiguration
+	err := envconfig.Process(envPrefix("bytes"), &c)
+	if err != nil {
+		return bytecodec.Options{}, err
+	}
+	sources, err := parseSources(c.Input, c.Cache)
+	if err != nil {
+		return bytecodec.Options{}, err
+	}
+	w, err := windowingOptions("bytes")
+	if err != nil {
+		return bytecodec.Options{}, err
+	}
+	s, err := samplingOptions("bytes")
+	return bytecodec.Options{
+		Sources:   sources,
+		BatchSize: c.BatchSize,
+		Epoch:     c.Epoch,
+		Windowing: w,
+		Sampling:  s,
+	}, err
+}
+
+// newCodec returns the codec selected by the configuration
+func newCodec() (codec.Codec, error) {
+	switch conf.Codec {
+	case "char":
+		opts, err := charOptions()
+		if err != nil {
+			return nil, err
+		}
+		return char.New(opts)
+	case "word":
+		opts, err := wordOptions()
+		if err != nil {
+			return nil, err
+		}
+		return word.New(opts)
+	case "bpe":
+		opts, err := bpeOptions()
+		if err != nil {
+			return nil, err
+		}
+		return bpe.New(opts)
+	case "bytes":
+		opts, err := bytesOptions()
+		if err != nil {
+			return nil, err
+		}
+		return bytecodec.New(opts)
+	default:
+		return nil, fmt.Errorf("Unknown codec %v, available codecs are %v", conf.Codec, codec.Codecs())
+	}
+}
+
+// configureCodec applies the environment to a restored codec.
+// The training options are only read (and required) for a training
+func configureCodec(cdc codec.Codec, training bool) error {
+	name, err := codec.Name(cdc)
+	if err != nil {
+		return err
+	}
+	if training {
+		switch c := cdc.(type) {
+		case *char.Char:
+			var opts char.Options
+			if opts, err = charOptions(); err == nil {
+				err = c.SetOptions(opts)
+			}
+		case *word.Word:
+			var opts word.Options
+			if opts, err = wordOptions(); err == nil {
+				err = c.SetOptions(opts)
+			}
+		case *bpe.BPE:
+			var opts bpe.Options
+			if opts, err = bpeOptions(); err == nil {
+				err = c.SetOptions(opts)
+			}
+		case *bytecodec.Bytes:
+			var opts bytecodec.Options
+			if opts, err = bytesOptions(); err == nil {
+				err = c.SetOptions(opts)
+			}
+		default:
+			err = fmt.Errorf("The %v codec cannot be configured for a training", name)
+		}
+		return err
+	}
+	if c, ok := cdc.(interface {
+		SetSampling(codec.Sampling)
+	}); ok {
+		s, err := samplingOptions(name)
+		if err != nil {
+			return err
+		}
+		c.SetSampling(s)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package rnn
+
+import (
+	"fmt"
+	"math"
+)
+
+// streams returns the sequences of the mini-batch
+func (tset TrainingSet) streams() []TrainingSet {
+	if len(tset.Streams) == 0 {
+		return []TrainingSet{tset}
+	}
+	return tset.Streams
+}
+
+// sequence holds the inputs or the targets of the streams of a mini-batch at every time step:
+// either dense vectors (the rows of dense[t]) or the indexes of one-hot vectors (sparse[t][stream])
+type sequence[T float] struct {
+	dense  []*matrix[T]
+	sparse [][]int
+}
+
+// minibatch gathers the inputs and the targets of the streams
+func minibatch[T float](streams []TrainingSet) (xs, ts sequence[T]) {
+	minibatchInto(streams, &xs, &ts)
+	return
+}
+
+// minibatchInto gathers the inputs and the targets of the streams into xs and ts,
+// reusing their memory. The dense vectors are converted to the precision of the sequences
+func minibatchInto[T float](streams []TrainingSet, xs, ts *sequence[T]) {
+	steps := streams[0].len()
+	sparse := streams[0].InputIndexes != nil
+	for _, s := range streams {
+		if s.len() != steps {
+			panic(fmt.Sprintf("rnn: the streams of a mini-batch must have the same length (%v and %v)", steps, s.len()))
+		}
+		if (s.InputIndexes != nil) != sparse {
+			panic("rnn: the streams of a mini-batch must all be dense or all be sparse")
+		}
+	}
+	if sparse {
+		xs.dense, ts.dense = nil, nil
+		xs.sparse = indexes(xs.sparse, steps, len(streams))
+		ts.sparse = indexes(ts.sparse, steps, len(streams))
+		for t := 0; t < steps; t++ {
+			for i, s := range streams {
+				xs.sparse[t][i] = s.InputIndexes[t]
+				ts.sparse[t][i] = s.TargetIndexes[t]
+			}
+		}
+		return
+	}
+	xs.sparse, ts.sparse = nil, nil
+	xs.dense = matrices(xs.dense, steps)
+	ts.dense = matrices(ts.dense, steps)
+	for t := 0; t < steps; t++ {
+		xs.dense[t] = reshape(xs.dense[t], len(streams), len(streams[0].Inputs[t]))
+		ts.dense[t] = reshape(ts.dense[t], len(streams), len(streams[0].Targets[t]))
+		for i, s := range streams {
+			convert(xs.dense[t].row(i), s.Inputs[t])
+			convert(ts.dense[t].row(i), s.Targets[t])
+		}
+	}
+}
+
+// indexes returns steps slices of n indexes, reusing the memory of s
+func indexes(s [][]int, steps, n int) [][]int {
+	if cap(s) < steps {
+		s = append(s[:cap(s)], make([][]int, steps-cap(s))...)
+	}
+	s = s[:steps]
+	for t := range s {
+		if cap(s[t]) < n {
+			s[t] = make([]int, n)
+		}
+		s[t] = s[t][:n]
+	}
+	return s
+}
+
+// reshape returns m if it has r rows and c columns, or a new matrix
+func reshape[T float](m *matrix[T], r, c int) *matrix[T] {
+	if m == nil || m.rows != r || m.cols != c {
+		return newMatrix[T](r, c)
+	}
+	return m
+}
+
+// len returns the number of time steps of the sequence
+func (s sequence[T]) len() int {
+	if s.sparse != nil {
+		return len(s.sparse)
+	}
+	return len(s.dense)
+}
+
+// slice returns the time steps from start to end of the sequence
+func (s sequence[T]) slice(start, end int) sequence[T] {
+	if s.sparse != nil {
+		return sequence[T]{sparse: s.sparse[start:end]}
+	}
+	return sequence[T]{dense: s.dense[start:end]}
+}
+
+// len returns the number of time steps of the training set
+func (tset TrainingSet) len() int {
+	if tset.InputIndexes != nil {
+		if len(tset.TargetIndexes) != len(tset.InputIndexes) {
+			panic("rnn: InputIndexes and TargetIndexes must have the same length")
+		}
+		return len(tset.InputIndexes)
+	}
+	if len(tset.Targets) != len(tset.Inputs) {
+		panic("rnn: Inputs and Targets must have the same length")
+	}
+	return len(tset.Inputs)
+}
+
+// slice returns the time steps from lo to hi of the training set, with its Reset flag
+func (tset TrainingSet) slice(lo, hi int) TrainingSet {
+	if tset.InputIndexes != nil {
+		return TrainingSet{InputIndexes: tset.InputIndexes[lo:hi], TargetIndexes: tset.TargetIndexes[lo:hi], Reset: tset.Reset}
+	}
+	return TrainingSet{Inputs: tset.Inputs[lo:hi], Targets: tset.Targets[lo:hi], Reset: tset.Reset}
+}
+
+// mulT sets h to the product of the inputs at time t, multiplied by the optional mask,
+// by the transpose of w: with sparse inputs, the rows of h are copies of the columns of w
+// The workspace ws provides the temporary matrices
+func (s sequence[T]) mulT(ws *workspace[T], h *matrix[T], t int, w, mask *matrix[T]) {
+	if s.sparse == nil {
+		mulT(h, ws.dropped(s.dense[t], mask), w)
+		return
+	}
+	for i, ix := range s.sparse[t] {
+		m := T(1)
+		if mask != nil {
+			m = mask.at(i, ix)
+		}
+		row := h.row(i)
+		for j := range row {
+			row[j] = m * w.data[j*w.cols+ix]
+		}
+	}
+}
+
+// addOuter adds to dw the product of the transpose of d by the inputs at time t,
+// multiplied by the optional mask: with sparse inputs, the rows of d are added to the columns of dw
+// The workspace ws provides the temporary matrices
+func (s sequence[T]) addOuter(ws *workspace[T], dw, d *matrix[T], t int, mask *matrix[T]) {
+	if s.sparse == nil {
+		addTMul(dw, d, ws.dropped(s.dense[t], mask))
+		return
+	}
+	for i, ix := range s.sparse[t] {
+		m := T(1)
+		if mask != nil {
+			m = mask.at(i, ix)
+		}
+		for j, v := range d.row(i) {
+			dw.data[j*dw.cols+ix] += m * v
+		}
+	}
+}
+
+// sub sets d to the difference between the probabilities p and the targets at time t
+func (s sequence[T]) sub(d, p *matrix[T], t int) {
+	copy(d.data, p.data)
+	if s.sparse == nil {
+		axpy(d.data, -1, s.dense[t].data)
+		return
+	}
+	for i, ix := range s.sparse[t] {
+		d.row(i)[ix]--
+	}
+}
+
+// crossEntropy returns the loss of the probabilities ps for the targets ts,
+// summed over the time steps and the streams; it is computed in float64 whatever the precision
+func crossEntropy[T float](ps []*matrix[T], ts sequence[T]) float64 {
+	loss := float64(0)
+	for t := range ps {
+		for i := 0; i < ps[t].rows; i++ {
+			p := ps[t].row(i)
+			if ts.sparse != nil {
+				loss -= math.Log(float64(p[ts.sparse[t][i]]))
+				continue
+			}
+			target := ts.dense[t].row(i)
+			l := float64(0)
+			for j := range p {
+				l += float64(p[j]) * float64(target[j])
+			}
+			loss -= math.Log(l)
+		}
+	}
+	return loss
+}
+
+// softmax replaces y+bias by its normalized probabilities
+func softmax[T float](y, bias []T) {
+	max := T(math.Inf(-1))
+	for i := range y {
+		y[i] += bias[i]
+		if y[i] > max {
+			max = y[i]
+		}
+	}
+	s := T(0)
+	for i := range y {
+		y[i] = T(math.Exp(float64(y[i] - max)))
+		s += y[i]
+	}
+	for i := range y {
+		y[i] /= s
+	}
+}
+
+// addRows adds the rows of m to v
+func addRows[T float](v []T, m *matrix[T]) {
+	for i := 0; i < m.rows; i++ {
+		axpy(v, 1, m.row(i))
+	}
+}
@@ -0,0 +1,86 @@
+package rnn
+
+import (
+	"math/rand"
+)
+
+// bptt walks through the inputs xs and the targets ts of a mini-batch
+// with a truncated backpropagation through time: every k1 steps, the errors of the last k1 outputs
+// are backpropagated through the last k2 steps
+type bptt[T float] struct {
+	xs, ts sequence[T]
+	k1, k2 int
+	// states[t] is the hidden state before the time step t
+	states []*matrix[T]
+	// from is the first time step of the next outputs
+	from int
+	// ws holds the matrices of the passes, reused from a window to the next
+	ws workspace[T]
+}
+
+// newBPTT starts the walk from the initial hidden states h0 of the streams, one per row
+func (rnn *network[T]) newBPTT(xs, ts sequence[T], h0 *matrix[T]) *bptt[T] {
+	p := &bptt[T]{}
+	p.restart(rnn, xs, ts, h0)
+	return p
+}
+
+// restart starts a new walk from the initial hidden states h0, reusing the memory of the previous walk
+func (p *bptt[T]) restart(rnn *network[T], xs, ts sequence[T], h0 *matrix[T]) {
+	p.xs, p.ts, p.from = xs, ts, 0
+	p.k1, p.k2 = rnn.config.truncation(xs.len())
+	b, c := h0.dims()
+	if cap(p.states) < xs.len()+1 {
+		p.states = append(p.states[:cap(p.states)], make([]*matrix[T], xs.len()+1-cap(p.states))...)
+	}
+	p.states = p.states[:xs.len()+1]
+	for t := range p.states {
+		p.states[t] = reshape(p.states[t], b, c)
+	}
+	copy(p.states[0].data, h0.data)
+}
+
+// done reports whether all the outputs are backpropagated
+func (p *bptt[T]) done() bool {
+	return p.from >= p.xs.len()
+}
+
+// next returns the loss of the next k1 outputs, averaged over the streams, and their gradients
+func (p *bptt[T]) next(rnn *network[T], rnd *rand.Rand) (float64, *parameters[T]) {
+	b, _ := p.states[0].dims()
+	n := p.xs.len()
+	end := p.from + p.k1
+	if end > n {
+		end = n
+	}
+	start := end - p.k2
+	if start < 0 {
+		start = 0
+	}
+	f := rnn.forwardPass(&p.ws, p.xs.slice(start, end), p.states[start], rnd)
+	for t, h := range f.hs {
+		copy(p.states[start+t+1].data, h.data)
+	}
+	targets := p.ts.slice(start, end)
+	loss := crossEntropy(f.ps[p.from-start:], targets.slice(p.from-start, end-start)) / float64(b)
+	g := rnn.backPropagation(f, targets, p.from-start)
+	p.from = end
+	return loss, g
+}
+
+// last returns the hidden states after the last time step
+func (p *bptt[T]) last() *matrix[T] {
+	return p.states[p.xs.len()]
+}
+
+// trainSequence trains the rnn on the whole walk, giving the gradients to the optimizer.
+// It returns the loss, summed over the steps and averaged over the streams
+func (rnn *network[T]) trainSequence(p *bptt[T], rnd *rand.Rand, opt *optimizer[T]) float64 {
+	loss := float64(0)
+	for !p.done() {
+		l, g := p.next(rnn, rnd)
+		loss += l
+		opt.step(rnn, g)
+	}
+	return loss
+}
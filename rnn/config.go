@@ -1,14 +1,82 @@
 package rnn
 
+import (
+	"math/rand"
+	"time"
+)
+
 // NeuralNetConfig defines our neural network
 // architecture and learning parameters.
 type neuralNetConfig struct {
 	InputNeurons   int
 	OutputNeurons  int
 	HiddenNeurons  int     `default:"100" required:"true"`
+	EmbeddingSize  int     `default:"0"`
 	LearningRate   float64 `default:"1e-1" required:"true"`
 	AdagradEpsilon float64 `default:"1e-8" required:"true"`
-	RandomFactor   float64 `default:"0.01" required":"true"`
+	RandomFactor   float64 `default:"0.01" required:"true"`
+	// Number of bits of the parameters and of the computations: 64, or 32 to halve the memory
+	// and the size of the checkpoints
+	Precision int `default:"64"`
+	// Normalize the pre-activation of the recurrent cell with a learned gain (the bias is bh)
+	LayerNorm bool `default:"false"`
+	// Truncated backpropagation through time: the parameters are updated every UpdateSteps (k1) steps
+	// (0 means once per TrainingSet) by backpropagating through the last BackpropSteps (k2) steps
+	// (at least k1)
+	UpdateSteps   int `default:"0"`
+	BackpropSteps int `default:"0"`
+	// Number of goroutines of the data-parallel training (see TrainParallel)
+	Workers int `default:"1"`
+	// The workers update the parameters asynchronously, without lock (see TrainHogwild)
+	Asynchronous bool `default:"false"`
+	// Seed of the random numbers (initialization and dropout); 0 means a seed based on the time
+	Seed int64 `default:"0"`
+	// Number of gradients averaged before an update of the parameters
+	AccumulationSteps int `default:"1"`
+	// Dropout rates, applied during the training only
+	InputDropout     float64 `default:"0"`
+	OutputDropout    float64 `default:"0"`
+	RecurrentDropout float64 `default:"0"`
+	// L2 weight decay of the parameter groups listed in DecayedParameters (wex, wxh, whh, why, bh, by),
+	// added to the gradients or, if decoupled, applied to the parameters after the adaptation.
+	// A group may be given its own value (whh:1e-4,why:0)
+	WeightDecay          float64  `default:"0"`
+	DecoupledWeightDecay bool     `default:"false"`
+	DecayedParameters    []string `default:"wex,wxh,whh,why"`
+	// Maximum norm of the rows of the parameter groups listed in MaxNormParameters (0 disables it);
+	// as for the weight decay, a group may be given its own value (whh:3)
+	MaxNorm           float64  `default:"0"`
+	MaxNormParameters []string `default:"whh"`
+}
+
+// inputSize is the size of the vectors that enter the recurrent cell
+func (c neuralNetConfig) inputSize() int {
+	if c.EmbeddingSize > 0 {
+		return c.EmbeddingSize
+	}
+	return c.InputNeurons
+}
+
+// truncation returns the number of steps k1 between two updates and the number of steps k2
+// of the backpropagation for a sequence of n steps; k2 is at least k1
+func (c neuralNetConfig) truncation(n int) (k1, k2 int) {
+	k1, k2 = c.UpdateSteps, c.BackpropSteps
+	if k1 <= 0 || k1 > n {
+		k1 = n
+	}
+	if k2 < k1 {
+		k2 = k1
+	}
+	return k1, k2
+}
+
+// newRand returns the i-th source of random numbers, seeded with Seed if it is set
+func (c neuralNetConfig) newRand(i int) *rand.Rand {
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed + int64(i)))
 }
 
 //var conf neuralNetConfig
@@ -0,0 +1,59 @@
+package rnn
+
+import "math"
+
+// minProbability is the lowest probability of an element: the probabilities that underflow to zero
+// are clamped to it so that the log-probabilities stay finite
+const minProbability = 1e-300
+
+// Evaluation holds the score of a sequence computed by the network
+type Evaluation struct {
+	// LogProbs holds the natural log-probability of each scored element of the sequence
+	// given all the elements seen before it.
+	// Without a prefix, the first element has nothing to be conditioned on and it is not scored
+	LogProbs []float64
+	// Offset is the index in the sequence of the element of LogProbs[0]:
+	// 1 without a prefix, 0 otherwise
+	Offset int
+	// LogLikelihood is the sum of the log-probabilities of the scored elements
+	LogLikelihood float64
+	// Perplexity is exp(-LogLikelihood/n) where n is the number of scored elements
+	Perplexity float64
+}
+
+// Evaluate the sequence xs against the network.
+// The optional prefix is passed through the network first to condition the
+// hidden state; it is not scored.
+// Evaluate does not modify the network and can be called during the training
+func (rnn *RNN) Evaluate(prefix, xs [][]float64) Evaluation {
+	return rnn.net.evaluate(prefix, xs)
+}
+
+// evaluate implements RNN.Evaluate
+func (rnn *network[T]) evaluate(prefix, xs [][]float64) Evaluation {
+	c := rnn.newCell()
+	var p []T
+	for _, x := range prefix {
+		p = rnn.step(c, x)
+	}
+	var ev Evaluation
+	if p == nil && len(xs) > 0 {
+		ev.Offset = 1
+	}
+	for _, x := range xs {
+		if p != nil {
+			l := float64(0)
+			for j := range p {
+				l += float64(p[j]) * x[j]
+			}
+			lp := math.Log(math.Max(l, minProbability))
+			ev.LogProbs = append(ev.LogProbs, lp)
+			ev.LogLikelihood += lp
+		}
+		p = rnn.step(c, x)
+	}
+	if n := len(ev.LogProbs); n > 0 {
+		ev.Perplexity = math.Exp(-ev.LogLikelihood / float64(n))
+	}
+	return ev
+}
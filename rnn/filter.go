@@ -0,0 +1,118 @@
+package rnn
+
+import "math"
+
+// Filter adjusts, in place, the probability distribution p of the next element
+// before it is sampled.
+// history holds the elements seen so far: the inputs that primed the network
+// followed by the elements already generated
+type Filter func(p []float64, history [][]float64)
+
+// Constrain returns a Filter that only allows the entries of the distribution
+// for which allowed returns true.
+// The distribution is renormalized over the allowed entries; if the network gives
+// them all a null probability, they are considered equally likely.
+// If no entry is allowed, the distribution is left untouched
+func Constrain(allowed func(ix int, history [][]float64) bool) Filter {
+	return func(p []float64, history [][]float64) {
+		mask := make([]bool, len(p))
+		n := 0
+		total := float64(0)
+		for i := range p {
+			if allowed(i, history) {
+				mask[i] = true
+				total += p[i]
+				n++
+			}
+		}
+		if n == 0 {
+			return
+		}
+		for i := range p {
+			switch {
+			case !mask[i]:
+				p[i] = 0
+			case total == 0:
+				p[i] = 1 / float64(n)
+			default:
+				p[i] /= total
+			}
+		}
+	}
+}
+
+// Mask returns a Filter that only allows the entries set to true in mask
+func Mask(mask []bool) Filter {
+	return Constrain(func(ix int, _ [][]float64) bool {
+		return ix < len(mask) && mask[ix]
+	})
+}
+
+// Penalize returns a Filter that lowers the probability of the elements
+// found in the last window elements of the history (the whole history if window is 0).
+// An element seen c times has its log-probability decreased by frequency*c + presence
+// before the distribution is renormalized
+func Penalize(frequency, presence float64, window int) Filter {
+	return func(p []float64, history [][]float64) {
+		if window > 0 && len(history) > window {
+			history = history[len(history)-window:]
+		}
+		counts := make([]int, len(p))
+		for _, x := range history {
+			counts[argmax(x)]++
+		}
+		for i, c := range counts {
+			if c > 0 {
+				p[i] *= math.Exp(-frequency*float64(c) - presence)
+			}
+		}
+		normalize(p)
+	}
+}
+
+// BlockNgrams returns a Filter that forbids the elements that would repeat
+// an n-gram already present in the history.
+// If every possible element is forbidden, the distribution is left untouched
+func BlockNgrams(n int) Filter {
+	return func(p []float64, history [][]float64) {
+		if n <= 0 || len(history) < n {
+			return
+		}
+		ixs := make([]int, len(history))
+		for i, x := range history {
+			ixs[i] = argmax(x)
+		}
+		// the n-1 last elements are the beginning of the next n-gram
+		tail := ixs[len(ixs)-n+1:]
+		blocked := make([]bool, len(p))
+	ngrams:
+		for start := 0; start+n <= len(ixs); start++ {
+			for j, ix := range tail {
+				if ixs[start+j] != ix {
+					continue ngrams
+				}
+			}
+			blocked[ixs[start+n-1]] = true
+		}
+		q := make([]float64, len(p))
+		for i := range p {
+			if !blocked[i] {
+				q[i] = p[i]
+			}
+		}
+		if normalize(q) {
+			copy(p, q)
+		}
+	}
+}
+
+// Temperature returns a Filter that sharpens (t < 1) or flattens (t > 1)
+// the distribution by raising its probabilities to the power of 1/t
+func Temperature(t float64) Filter {
+	return func(p []float64, _ [][]float64) {
+		for i := range p {
+			p[i] = math.Pow(p[i], 1/t)
+		}
+		normalize(p)
+	}
+}
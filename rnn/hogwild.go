@@ -0,0 +1,53 @@
+package rnn
+
+import "sync"
+
+// TrainHogwild is an asynchronous, lock-free Train (Hogwild): the workers take the TrainingSets from the feed
+// as they are ready and apply their updates to the shared parameters and the shared memory of adagrad
+// without any synchronization.
+// A worker takes any TrainingSet, so the hidden states are not carried from a TrainingSet to the next:
+// every TrainingSet starts from zero hidden states, whatever its Reset flags.
+// It is meant for the throughput on big corpora: the updates may overwrite each other, the order
+// of the TrainingSets depends on the scheduling, so the training is not deterministic even if the Seed is set.
+// The losses are sent on the info channel as best-effort: a loss is dropped if the previous one has not been read yet.
+// The info channel is closed once all the workers are done
+func (rnn *RNN) TrainHogwild(workers int) (chan<- TrainingSet, <-chan float64) {
+	return rnn.net.trainHogwild(workers)
+}
+
+// trainHogwild implements RNN.TrainHogwild
+func (rnn *network[T]) trainHogwild(workers int) (chan<- TrainingSet, <-chan float64) {
+	feed := make(chan TrainingSet, workers)
+	info := make(chan float64, 1)
+
+	adagrad := newAdagrad[T](rnn.config)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		// The hidden states of the worker are never saved and stay at zero
+		w := rnn.newWorker(i)
+		// Each worker accumulates its own gradients
+		opt := &optimizer[T]{
+			adagrad: adagrad,
+			sum:     newParameters[T](rnn.config),
+		}
+		wg.Add(1)
+		go func(w *worker[T], opt *optimizer[T]) {
+			defer wg.Done()
+			for tset := range feed {
+				p := w.start(rnn, tset)
+				loss := rnn.trainSequence(p, w.rnd, opt)
+				// Send info on a non blocking channel
+				select {
+				case info <- loss:
+				default:
+				}
+			}
+			opt.flush(rnn)
+		}(w, opt)
+	}
+	go func() {
+		wg.Wait()
+		close(info)
+	}()
+	return feed, info
+}
@@ -0,0 +1,44 @@
+package rnn
+
+import "math"
+
+// layerNormEpsilon is added to the variance to avoid a division by zero
+const layerNormEpsilon = 1e-5
+
+// layerNorm normalizes the pre-activation a to a zero mean and a unit variance,
+// stores the normalized values in n and sets a to their product by the gain.
+// It returns the standard deviation of a, needed by the backpropagation.
+// The statistics are computed in float64 whatever the precision
+func layerNorm[T float](a, n, gain []T) T {
+	mean := float64(0)
+	for _, v := range a {
+		mean += float64(v)
+	}
+	mean /= float64(len(a))
+	variance := float64(0)
+	for _, v := range a {
+		variance += (float64(v) - mean) * (float64(v) - mean)
+	}
+	sigma := math.Sqrt(variance/float64(len(a)) + layerNormEpsilon)
+	for i, v := range a {
+		n[i] = T((float64(v) - mean) / sigma)
+		a[i] = gain[i] * n[i]
+	}
+	return T(sigma)
+}
+
+// layerNormBackward replaces d, the derivative of the output of layerNorm,
+// by the derivative of its input a and adds the derivative of the gain to dgain
+func layerNormBackward[T float](d, n, gain, dgain []T, sigma T) {
+	k := float64(len(d))
+	dmean, dnmean := float64(0), float64(0)
+	for i := range d {
+		dgain[i] += d[i] * n[i]
+		d[i] *= gain[i]
+		dmean += float64(d[i]) / k
+		dnmean += float64(d[i]) * float64(n[i]) / k
+	}
+	for i := range d {
+		d[i] = T((float64(d[i]) - dmean - float64(n[i])*dnmean) / float64(sigma))
+	}
+}
@@ -1,41 +1,140 @@
 package rnn
 
-import (
-	"math"
+// float is the type of the parameters and of the computations of the network:
+// float64, or float32 to halve the memory (see RNN_PRECISION)
+type float interface {
+	float32 | float64
+}
 
-	"github.com/gonum/matrix/mat64"
-)
+// matrix is a dense matrix stored row by row
+type matrix[T float] struct {
+	rows, cols int
+	data       []T
+}
 
-func tanh(a []float64) []float64 {
-	ret := make([]float64, len(a))
-	for i, v := range a {
-		ret[i] = math.Tanh(v)
+// newMatrix returns a zero matrix of r rows and c columns
+func newMatrix[T float](r, c int) *matrix[T] {
+	return &matrix[T]{rows: r, cols: c, data: make([]T, r*c)}
+}
+
+// dims returns the number of rows and columns of m
+func (m *matrix[T]) dims() (r, c int) {
+	return m.rows, m.cols
+}
+
+// row returns the i-th row of m (not a copy)
+func (m *matrix[T]) row(i int) []T {
+	return m.data[i*m.cols : (i+1)*m.cols]
+}
+
+// at returns the element of m at row i and column j
+func (m *matrix[T]) at(i, j int) T {
+	return m.data[i*m.cols+j]
+}
+
+// The products of matrices below work on the rows of the matrices in place:
+// they never allocate
+
+// mul sets c to the product of a by b
+func mul[T float](c, a, b *matrix[T]) {
+	zero(c.data)
+	addMul(c, a, b)
+}
+
+// addMul adds to c the product of a by b
+func addMul[T float](c, a, b *matrix[T]) {
+	for i := 0; i < a.rows; i++ {
+		crow := c.row(i)
+		for k, aik := range a.row(i) {
+			if aik != 0 {
+				axpy(crow, aik, b.row(k))
+			}
+		}
 	}
-	return ret
 }
 
-func dotVec(a, b []float64) *mat64.Dense {
-	va := mat64.NewDense(len(a), 1, a)
-	vb := mat64.NewDense(1, len(b), b)
-	ret := new(mat64.Dense)
-	ret.Mul(va, vb)
-	return ret
+// mulT sets c to the product of a by the transpose of b
+func mulT[T float](c, a, b *matrix[T]) {
+	zero(c.data)
+	addMulT(c, a, b)
 }
-func dot(a mat64.Matrix, b []float64) []float64 {
-	t := mat64.NewDense(len(b), 1, b)
-	row, _ := a.Dims()
-	backend := make([]float64, row)
-	r := mat64.NewDense(row, 1, backend)
-	r.Mul(a, t)
-	return backend
+
+// addMulT adds to c the product of a by the transpose of b
+func addMulT[T float](c, a, b *matrix[T]) {
+	for i := 0; i < a.rows; i++ {
+		crow := c.row(i)
+		arow := a.row(i)
+		for j := range crow {
+			crow[j] += dot(arow, b.row(j))
+		}
+	}
 }
 
-func exp(a []float64) []float64 {
-	ret := make([]float64, len(a))
-	for i, v := range a {
-		ret[i] = math.Exp(v)
+// addTMul adds to c the product of the transpose of a by b
+func addTMul[T float](c, a, b *matrix[T]) {
+	for i := 0; i < a.rows; i++ {
+		brow := b.row(i)
+		for k, aik := range a.row(i) {
+			if aik != 0 {
+				axpy(c.row(k), aik, brow)
+			}
+		}
+	}
+}
+
+// mulVec sets y to the product of the matrix a by the vector x
+func mulVec[T float](y []T, a *matrix[T], x []T) {
+	for i := range y {
+		y[i] = dot(a.row(i), x)
+	}
+}
+
+// dot returns the scalar product of a and b
+func dot[T float](a, b []T) T {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 T
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return s0 + s1 + s2 + s3
+}
+
+// axpy adds alpha*x to y
+func axpy[T float](y []T, alpha T, x []T) {
+	x = x[:len(y)]
+	for i, v := range x {
+		y[i] += alpha * v
+	}
+}
+
+// scale multiplies the elements of v by s
+func scale[T float](v []T, s T) {
+	for i := range v {
+		v[i] *= s
+	}
+}
+
+// mulElem multiplies the elements of v by the elements of m
+func mulElem[T float](v, m []T) {
+	m = m[:len(v)]
+	for i := range v {
+		v[i] *= m[i]
+	}
+}
+
+// convert copies src into dst, converting its elements to the precision of dst
+func convert[D, S float](dst []D, src []S) {
+	src = src[:len(dst)]
+	for i, v := range src {
+		dst[i] = D(v)
 	}
-	return ret
 }
 
 func sum(a []float64) float64 {
@@ -45,34 +144,28 @@ func sum(a []float64) float64 {
 	}
 	return res
 }
-func div(a []float64, val float64) []float64 {
-	ret := make([]float64, len(a))
-	for i, v := range a {
-		ret[i] = v / val
-	}
-	return ret
 
+// normalize p in place so that it sums to one.
+// It returns false and leaves p untouched if p sums to zero
+func normalize(p []float64) bool {
+	total := sum(p)
+	if total == 0 {
+		return false
+	}
+	for i := range p {
+		p[i] /= total
+	}
+	return true
 }
-func add(a ...[]float64) []float64 {
-	ret := make([]float64, len(a[0]))
-	for _, element := range a {
-		for i, v := range element {
-			ret[i] += v
+
+// argmax returns the index of the greatest element of a,
+// which is the index of the 1 for a 1-of-K encoded vector
+func argmax(a []float64) int {
+	idx := 0
+	for i, v := range a {
+		if v > a[idx] {
+			idx = i
 		}
 	}
-	return ret
-}
-
-// Calculate the normalized probability of the second dimension
-// of the array
-func normalizeByRow(ys [][]float64) (ps [][]float64) {
-	inputSize := len(ys)
-	// probabilities for next chars
-	ps = make([][]float64, inputSize)
-	for t := 0; t < inputSize; t++ {
-		ps[t] = make([]float64, len(ys[t]))
-		expYS := exp(ys[t])
-		ps[t] = div(expYS, sum(expYS))
-	}
-	return
+	return idx
 }
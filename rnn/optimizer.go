@@ -0,0 +1,59 @@
+package rnn
+
+// optimizer updates the parameters of the rnn with the average of the gradients
+// accumulated over AccumulationSteps updates
+type optimizer[T float] struct {
+	adagrad *adagrad[T]
+	sum     *parameters[T] // sum of the accumulated gradients
+	count   int
+}
+
+// newOptimizer returns an optimizer suitable to the rnn shape
+func newOptimizer[T float](c neuralNetConfig) *optimizer[T] {
+	return &optimizer[T]{
+		adagrad: newAdagrad[T](c),
+		sum:     newParameters[T](c),
+	}
+}
+
+// step accumulates the gradients g; once enough of them are accumulated,
+// their average is regularized, clipped and applied to the parameters
+func (o *optimizer[T]) step(rnn *network[T], g *parameters[T]) {
+	n := rnn.config.AccumulationSteps
+	if n <= 1 {
+		o.update(rnn, g)
+		return
+	}
+	for i := 0; i < g.count(); i++ {
+		axpy(o.sum.at(i), 1, g.at(i))
+	}
+	o.count++
+	if o.count < n {
+		return
+	}
+	o.flush(rnn)
+}
+
+// flush applies the average of the gradients accumulated so far, if any.
+// It is called at the end of the training so that the last, partial accumulation is not lost
+func (o *optimizer[T]) flush(rnn *network[T]) {
+	if o.count == 0 {
+		return
+	}
+	for i := 0; i < o.sum.count(); i++ {
+		scale(o.sum.at(i), T(1/float64(o.count)))
+	}
+	o.update(rnn, o.sum)
+	o.sum.zero()
+	o.count = 0
+}
+
+// update the parameters with the gradients g
+func (o *optimizer[T]) update(rnn *network[T], g *parameters[T]) {
+	rnn.decay(g)
+	// Clip to mitigate exploding gradients
+	g.clip(1)
+	// Adaptation
+	o.adagrad.apply(rnn, g)
+	rnn.regularize()
+}
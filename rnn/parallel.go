@@ -0,0 +1,213 @@
+package rnn
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// worker trains on a shard of the feed with its own hidden states and source of dropout masks
+type worker[T float] struct {
+	hprevs [][]T // hidden states of the streams
+	rnd    *rand.Rand
+	// The memory of the mini-batches, reused from one to the next
+	xs, ts sequence[T]
+	h0     *matrix[T]
+	walk   bptt[T]
+}
+
+// newWorker returns the i-th worker, with zero hidden states
+func (rnn *network[T]) newWorker(i int) *worker[T] {
+	return &worker[T]{
+		rnd: rnn.config.newRand(i + 1),
+	}
+}
+
+// start returns the walk through the mini-batch tset from the hidden states of the worker
+func (w *worker[T]) start(rnn *network[T], tset TrainingSet) *bptt[T] {
+	streams := tset.streams()
+	for len(w.hprevs) < len(streams) {
+		w.hprevs = append(w.hprevs, make([]T, rnn.config.HiddenNeurons))
+	}
+	w.h0 = reshape(w.h0, len(streams), rnn.config.HiddenNeurons)
+	for i, s := range streams {
+		if s.Reset {
+			zero(w.h0.row(i))
+		} else {
+			copy(w.h0.row(i), w.hprevs[i])
+		}
+	}
+	minibatchInto(streams, &w.xs, &w.ts)
+	w.walk.restart(rnn, w.xs, w.ts, w.h0)
+	return &w.walk
+}
+
+// save keeps the last hidden states of the walk for the next mini-batch
+func (w *worker[T]) save(p *bptt[T]) {
+	h := p.last()
+	for i := 0; i < h.rows; i++ {
+		copy(w.hprevs[i], h.row(i))
+	}
+}
+
+// TrainParallel is a data-parallel Train: the streams of every mini-batch are split among the workers,
+// a worker always getting the same streams. Every worker carries the hidden states of its streams
+// from a mini-batch to the next, as Train does, so they follow the parts of the corpus the streams read.
+// For every update, the workers compute the gradients of their streams concurrently and the optimizer
+// is given their average weighted by the number of streams: the training is the one of Train on
+// the whole mini-batch. A mini-batch is never split further than its streams, so there should be
+// at least as many streams as workers, the extra workers being idle.
+// A single sequence (a TrainingSet without Streams or with a single stream) is cut in contiguous chunks
+// instead, one per worker: the first chunk starts from the hidden state carried from the previous
+// TrainingSet and the others from zero hidden states, as their actual states are only known once the
+// previous chunks are trained.
+// The training is deterministic if the Seed is set
+func (rnn *RNN) TrainParallel(workers int) (chan<- TrainingSet, <-chan float64) {
+	return rnn.net.trainParallel(workers)
+}
+
+// bounds returns the part [lo, hi) of n elements given to the i-th of the workers.
+// The parts differ by one element at most and the first elements go to the first workers
+func bounds(n, i, workers int) (lo, hi int) {
+	q, r := n/workers, n%workers
+	lo = i*q + min(i, r)
+	hi = lo + q
+	if i < r {
+		hi++
+	}
+	return lo, hi
+}
+
+// share returns the streams of the mini-batch trained by the i-th of the workers
+func share(streams []TrainingSet, i, workers int) []TrainingSet {
+	lo, hi := bounds(len(streams), i, workers)
+	return streams[lo:hi]
+}
+
+// trainParallel implements RNN.TrainParallel
+func (rnn *network[T]) trainParallel(workers int) (chan<- TrainingSet, <-chan float64) {
+	feed := make(chan TrainingSet, 1)
+	info := make(chan float64, 1)
+
+	opt := newOptimizer[T](rnn.config)
+	ws := make([]*worker[T], workers)
+	for i := range ws {
+		ws[i] = rnn.newWorker(i)
+	}
+	ws[0].hprevs = [][]T{rnn.hprev}
+	// The average of the gradients of the workers
+	avg := newParameters[T](rnn.config)
+	go func(feed <-chan TrainingSet, info chan<- float64) {
+		defer close(info)
+		for tset := range feed {
+			var loss float64
+			if streams := tset.streams(); len(streams) == 1 && workers > 1 {
+				loss = rnn.trainChunks(streams[0], ws, opt, avg)
+			} else {
+				loss = rnn.trainStreams(streams, ws, opt, avg)
+			}
+			// Send info on a non blocking channel
+			select {
+			case info <- loss:
+			default:
+			}
+		}
+		opt.flush(rnn)
+	}(feed, info)
+	return feed, info
+}
+
+// trainStreams trains the rnn on the streams of a mini-batch split among the workers and returns the loss,
+// averaged over the streams. Every worker carries the hidden states of its streams to the next mini-batch
+func (rnn *network[T]) trainStreams(streams []TrainingSet, ws []*worker[T], opt *optimizer[T], avg *parameters[T]) float64 {
+	walks := make([]*bptt[T], len(ws))
+	weights := make([]float64, len(ws))
+	for i, w := range ws {
+		mine := share(streams, i, len(ws))
+		if len(mine) == 0 {
+			continue
+		}
+		walks[i] = w.start(rnn, TrainingSet{Streams: mine})
+		weights[i] = float64(len(mine)) / float64(len(streams))
+	}
+	loss := float64(0)
+	for i, l := range rnn.trainRound(walks, weights, ws, opt, avg) {
+		loss += weights[i] * l
+	}
+	for i, p := range walks {
+		if p != nil {
+			ws[i].save(p)
+		}
+	}
+	return loss
+}
+
+// trainChunks trains the rnn on a single sequence cut in contiguous chunks, one per worker, and returns
+// the loss summed over the steps. The first worker carries the last hidden state of the sequence to the next TrainingSet
+func (rnn *network[T]) trainChunks(s TrainingSet, ws []*worker[T], opt *optimizer[T], avg *parameters[T]) float64 {
+	n := s.len()
+	walks := make([]*bptt[T], len(ws))
+	weights := make([]float64, len(ws))
+	last := -1
+	for i, w := range ws {
+		lo, hi := bounds(n, i, len(ws))
+		if lo == hi {
+			continue
+		}
+		chunk := s.slice(lo, hi)
+		chunk.Reset = s.Reset || i > 0
+		walks[i] = w.start(rnn, chunk)
+		weights[i] = float64(hi-lo) / float64(n)
+		last = i
+	}
+	if last < 0 {
+		return 0
+	}
+	loss := float64(0)
+	for _, l := range rnn.trainRound(walks, weights, ws, opt, avg) {
+		loss += l
+	}
+	copy(ws[0].hprevs[0], walks[last].last().row(0))
+	return loss
+}
+
+// trainRound trains the rnn on the walks of the workers (nil for an idle worker) and returns their losses.
+// The gradients of the walks are computed concurrently and averaged in avg with the weights
+// before every step of the optimizer
+func (rnn *network[T]) trainRound(walks []*bptt[T], weights []float64, ws []*worker[T], opt *optimizer[T], avg *parameters[T]) []float64 {
+	losses := make([]float64, len(walks))
+	grads := make([]*parameters[T], len(walks))
+	for {
+		var wg sync.WaitGroup
+		for i, p := range walks {
+			grads[i] = nil
+			if p == nil || p.done() {
+				continue
+			}
+			wg.Add(1)
+			go func(i int, p *bptt[T]) {
+				defer wg.Done()
+				l, g := p.next(rnn, ws[i].rnd)
+				losses[i] += l
+				grads[i] = g
+			}(i, p)
+		}
+		wg.Wait()
+		// The average is computed in the order of the workers to be deterministic
+		avg.zero()
+		n := 0
+		for i, g := range grads {
+			if g == nil {
+				continue
+			}
+			n++
+			for k := 0; k < g.count(); k++ {
+				axpy(avg.at(k), T(weights[i]), g.at(k))
+			}
+		}
+		if n == 0 {
+			break
+		}
+		opt.step(rnn, avg)
+	}
+	return losses
+}
@@ -0,0 +1,120 @@
+package rnn
+
+// parameters holds matrices and vectors with the shape of the parameters of the rnn:
+// the parameters themselves, their gradients or the memory of the optimizer
+type parameters[T float] struct {
+	wex *matrix[T] // nil without embedding layer
+	wxh *matrix[T]
+	whh *matrix[T]
+	why *matrix[T]
+	bh  []T
+	by  []T
+	// gain of the layer normalization (nil without layer normalization)
+	gain []T
+}
+
+// newParameters returns zero parameters with the shape described by the configuration
+func newParameters[T float](c neuralNetConfig) *parameters[T] {
+	p := &parameters[T]{
+		wxh: newMatrix[T](c.HiddenNeurons, c.inputSize()),
+		whh: newMatrix[T](c.HiddenNeurons, c.HiddenNeurons),
+		why: newMatrix[T](c.OutputNeurons, c.HiddenNeurons),
+		bh:  make([]T, c.HiddenNeurons),
+		by:  make([]T, c.OutputNeurons),
+	}
+	if c.EmbeddingSize > 0 {
+		p.wex = newMatrix[T](c.EmbeddingSize, c.InputNeurons)
+	}
+	if c.LayerNorm {
+		p.gain = make([]T, c.HiddenNeurons)
+	}
+	return p
+}
+
+// params returns the parameters of the rnn (not a copy)
+func (rnn *network[T]) params() *parameters[T] {
+	return &parameters[T]{
+		wex:  rnn.wex,
+		wxh:  rnn.wxh,
+		whh:  rnn.whh,
+		why:  rnn.why,
+		bh:   rnn.bh,
+		by:   rnn.by,
+		gain: rnn.gain,
+	}
+}
+
+// raw returns the data of the matrices and the vectors, always in the same order
+func (p *parameters[T]) raw() [][]T {
+	raw := make([][]T, p.count())
+	for i := range raw {
+		raw[i] = p.at(i)
+	}
+	return raw
+}
+
+// count returns the number of matrices and vectors
+func (p *parameters[T]) count() int {
+	n := 5
+	if p.wex != nil {
+		n++
+	}
+	if p.gain != nil {
+		n++
+	}
+	return n
+}
+
+// at returns the data of the i-th matrix or vector in the order of raw;
+// unlike raw, it does not allocate
+func (p *parameters[T]) at(i int) []T {
+	switch i {
+	case 0:
+		return p.wxh.data
+	case 1:
+		return p.whh.data
+	case 2:
+		return p.why.data
+	case 3:
+		return p.bh
+	case 4:
+		return p.by
+	}
+	if p.wex != nil {
+		if i == 5 {
+			return p.wex.data
+		}
+		i--
+	}
+	return p.gain
+}
+
+// clip the values to [-limit, limit]
+func (p *parameters[T]) clip(limit T) {
+	for k := 0; k < p.count(); k++ {
+		param := p.at(k)
+		for i := range param {
+			if param[i] > limit {
+				param[i] = limit
+			}
+			if param[i] < -limit {
+				param[i] = -limit
+			}
+		}
+	}
+}
+
+// fits reports whether the parameters have the shape described by the configuration
+func (p *parameters[T]) fits(c neuralNetConfig) bool {
+	r, cols := p.wxh.dims()
+	o, _ := p.why.dims()
+	return r == c.HiddenNeurons && cols == c.inputSize() && o == c.OutputNeurons &&
+		(p.wex != nil) == (c.EmbeddingSize > 0) && (p.gain != nil) == c.LayerNorm
+}
+
+// zero sets all the values to zero
+func (p *parameters[T]) zero() {
+	for k := 0; k < p.count(); k++ {
+		zero(p.at(k))
+	}
+}
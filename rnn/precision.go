@@ -0,0 +1,106 @@
+package rnn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// checkPrecision returns an error if the precision is neither 32 nor 64 bits
+func checkPrecision(precision int) error {
+	if precision != 32 && precision != 64 {
+		return fmt.Errorf("rnn: unsupported precision %v (expected 32 or 64)", precision)
+	}
+	return nil
+}
+
+// Precision returns the number of bits of the parameters and of the computations: 32 or 64
+func (rnn *RNN) Precision() int {
+	return rnn.net.precision()
+}
+
+// precision implements RNN.Precision
+func (rnn *network[T]) precision() int {
+	return rnn.config.Precision
+}
+
+// Convert returns a copy of the network with the given precision (32 or 64 bits).
+// The conversion to float32 rounds the parameters; the conversion to float64 is exact.
+// The memory of the optimizer is not part of the network: the training starts afresh
+func (rnn *RNN) Convert(precision int) (*RNN, error) {
+	if err := checkPrecision(precision); err != nil {
+		return nil, err
+	}
+	return &RNN{net: rnn.net.convert(precision)}, nil
+}
+
+// convert implements RNN.Convert
+func (rnn *network[T]) convert(precision int) engine {
+	if precision == 32 {
+		return convertNetwork[float32](rnn, precision)
+	}
+	return convertNetwork[float64](rnn, precision)
+}
+
+// convertNetwork returns a copy of the network src with parameters of type D
+func convertNetwork[D, S float](src *network[S], precision int) *network[D] {
+	conf := src.config
+	conf.Precision = precision
+	dst := newNetwork[D](conf)
+	params, srcParams := dst.params(), src.params()
+	for i := 0; i < params.count(); i++ {
+		convert(params.at(i), srcParams.at(i))
+	}
+	convert(dst.hprev, src.hprev)
+	return dst
+}
+
+// backup32 returns the checkpoint of a network of 32 bits precision:
+// the parameter groups and hprev are stored by name in Float32
+func backup32[T float](rnn *network[T]) bkp {
+	b := bkp{
+		Float32: map[string][]byte{"hprev": float32Bytes(rnn.hprev)},
+		Config:  rnn.config,
+	}
+	params := rnn.params()
+	for _, name := range parameterGroups {
+		if data, _, ok := params.group(name); ok {
+			b.Float32[name] = float32Bytes(data)
+		}
+	}
+	return b
+}
+
+// restore32 returns the network of 32 bits precision saved by backup32
+func restore32(b bkp) (*network[float32], error) {
+	rnn := newNetwork[float32](b.Config)
+	params := rnn.params()
+	for _, name := range parameterGroups {
+		if data, _, ok := params.group(name); ok {
+			if err := fromFloat32Bytes(data, b.Float32[name], name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rnn, fromFloat32Bytes(rnn.hprev, b.Float32["hprev"], "hprev")
+}
+
+// float32Bytes returns the values of v as little-endian float32
+func float32Bytes[T float](v []T) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(float32(x)))
+	}
+	return b
+}
+
+// fromFloat32Bytes sets v to the little-endian float32 of b, saved under the name
+func fromFloat32Bytes(v []float32, b []byte, name string) error {
+	if len(b) != 4*len(v) {
+		return fmt.Errorf("rnn: corrupted checkpoint: %v holds %v bytes instead of %v", name, len(b), 4*len(v))
+	}
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return nil
+}
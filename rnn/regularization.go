@@ -0,0 +1,124 @@
+package rnn
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parameterGroups are the names of the parameter groups, as used in the configuration
+var parameterGroups = []string{"wex", "wxh", "whh", "why", "bh", "by", "gain"}
+
+// group returns the data of the named parameter group and the length of its rows
+// (a vector is a single row); ok is false if the group is absent
+func (p *parameters[T]) group(name string) (data []T, cols int, ok bool) {
+	var m *matrix[T]
+	switch name {
+	case "wex":
+		m = p.wex
+	case "wxh":
+		m = p.wxh
+	case "whh":
+		m = p.whh
+	case "why":
+		m = p.why
+	case "bh":
+		return p.bh, len(p.bh), true
+	case "by":
+		return p.by, len(p.by), true
+	case "gain":
+		return p.gain, len(p.gain), p.gain != nil
+	}
+	if m == nil {
+		return nil, 0, false
+	}
+	return m.data, m.cols, true
+}
+
+// groupValue splits an entry of a list of parameter groups, the name of a group optionally
+// followed by its own value (whh:1e-4); value is def if the entry has no value
+func groupValue(entry string, def float64) (name string, value float64, err error) {
+	name, v, ok := strings.Cut(entry, ":")
+	if !ok {
+		return name, def, nil
+	}
+	value, err = strconv.ParseFloat(v, 64)
+	if err != nil {
+		return name, def, fmt.Errorf("rnn: bad value of the parameter group %q: %v", entry, err)
+	}
+	return name, value, nil
+}
+
+// checkGroups returns an error if one of the entries is not a parameter group with an optional value
+func checkGroups(entries []string) error {
+	for _, entry := range entries {
+		name, _, err := groupValue(entry, 0)
+		if err != nil {
+			return err
+		}
+		known := false
+		for _, g := range parameterGroups {
+			known = known || name == g
+		}
+		if !known {
+			return fmt.Errorf("rnn: unknown parameter group %q (expected one of %v)", name, parameterGroups)
+		}
+	}
+	return nil
+}
+
+// decay adds the gradient of the L2 weight decay to g (coupled weight decay)
+func (rnn *network[T]) decay(g *parameters[T]) {
+	if rnn.config.DecoupledWeightDecay {
+		return
+	}
+	params := rnn.params()
+	for _, entry := range rnn.config.DecayedParameters {
+		name, wd, _ := groupValue(entry, rnn.config.WeightDecay)
+		w, _, ok := params.group(name)
+		if !ok || wd == 0 {
+			continue
+		}
+		d, _, _ := g.group(name)
+		axpy(d, T(wd), w)
+	}
+}
+
+// regularize is applied to the parameters after the adaptation:
+// it shrinks them by the decoupled weight decay (as in AdamW)
+// and rescales the rows whose norm exceeds the max-norm constraint
+func (rnn *network[T]) regularize() {
+	params := rnn.params()
+	if rnn.config.DecoupledWeightDecay {
+		for _, entry := range rnn.config.DecayedParameters {
+			name, wd, _ := groupValue(entry, rnn.config.WeightDecay)
+			w, _, ok := params.group(name)
+			if !ok || wd == 0 {
+				continue
+			}
+			scale(w, T(1-rnn.config.LearningRate*wd))
+		}
+	}
+	for _, entry := range rnn.config.MaxNormParameters {
+		name, maxNorm, _ := groupValue(entry, rnn.config.MaxNorm)
+		w, cols, ok := params.group(name)
+		if !ok || maxNorm <= 0 {
+			continue
+		}
+		for start := 0; start < len(w); start += cols {
+			row := w[start : start+cols]
+			norm := float64(0)
+			for _, v := range row {
+				norm += float64(v) * float64(v)
+			}
+			norm = math.Sqrt(norm)
+			if norm <= maxNorm {
+				continue
+			}
+			for i := range row {
+				row[i] *= T(maxNorm / norm)
+			}
+		}
+	}
+}
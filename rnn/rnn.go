@@ -6,38 +6,66 @@ import (
 	"log"
 	"math"
 	"math/rand"
-	"time"
 
 	"github.com/gonum/matrix/mat64"
 	"github.com/kelseyhightower/envconfig"
 )
 
-// RNN represents the neural network
-// This RNNs parameters are the three mat64rices whh, wxh, why.
+// RNN represents the neural network.
+// Its parameters and its computations are in float64 or in float32,
+// according to the precision of its configuration (see Convert)
+type RNN struct {
+	net engine
+}
+
+// engine is the network in one of the precisions
+type engine interface {
+	train() (chan<- TrainingSet, <-chan float64)
+	trainParallel(workers int) (chan<- TrainingSet, <-chan float64)
+	trainHogwild(workers int) (chan<- TrainingSet, <-chan float64)
+	predict(xs [][]float64, n int, adapt func([]float64) []float64, filters ...Filter) [][]float64
+	evaluate(prefix, xs [][]float64) Evaluation
+	embeddings() [][]float64
+	precision() int
+	convert(precision int) engine
+	backup() bkp
+}
+
+// network is the neural network with parameters of type T
+// This RNNs parameters are the three matrices whh, wxh, why.
 // hprev is the last known hidden vector, which is actually the memory of the RNN
 // bh, and by are the biais vectors respectivly for the hidden layer and the output layer
-type RNN struct {
-	whh *mat64.Dense // size is hiddenDimension * hiddenDimension
-	wxh *mat64.Dense //
-	why *mat64.Dense //
+type network[T float] struct {
+	wex *matrix[T] // embedding table, one column per input element (nil without embedding layer)
+	whh *matrix[T] // size is hiddenDimension * hiddenDimension
+	wxh *matrix[T] //
+	why *matrix[T] //
 	// This is the last known hidden vector that represents the memory of the RNN
 	// This is used only for training
-	hprev  []float64
-	bh     []float64 // This is the biais
-	by     []float64 // This is the biais
+	hprev  []T
+	bh     []T // This is the biais
+	by     []T // This is the biais
+	gain   []T // gain of the layer normalization (nil without layer normalization)
 	config neuralNetConfig
 }
 
+// bkp is the checkpoint of the network.
+// With a 64 bits precision, the parameters are stored in the matrices and the vectors;
+// with a 32 bits precision, they are stored in Float32
 type bkp struct {
+	Wex *mat64.Dense // embedding table, nil without embedding layer
 	Whh *mat64.Dense // size is hiddenDimension * hiddenDimension
 	Wxh *mat64.Dense //
 	Why *mat64.Dense //
 	// This is the last known hidden vector that represents the memory of the RNN
 	// This is used only for training
-	Hprev  []float64
-	Bh     []float64 // This is the biais
-	By     []float64 // This is the biais
-	Config neuralNetConfig
+	Hprev []float64
+	Bh    []float64 // This is the biais
+	By    []float64 // This is the biais
+	Gain  []float64 // gain of the layer normalization, nil without layer normalization
+	// Float32 holds the parameter groups and hprev by name, as little-endian float32
+	Float32 map[string][]byte
+	Config  neuralNetConfig
 }
 
 // GobDecode the rnn for restoring
@@ -47,19 +75,37 @@ func (rnn *RNN) GobDecode(b []byte) error {
 
 	var backup bkp
 	err := dec.Decode(&backup)
-	rnn.bh = make([]float64, len(backup.Bh))
-	rnn.by = make([]float64, len(backup.By))
-	rnn.hprev = make([]float64, len(backup.Hprev))
-	if err == nil {
-		rnn.whh = backup.Whh
-		rnn.why = backup.Why
-		rnn.wxh = backup.Wxh
-		rnn.config = backup.Config
-		copy(rnn.bh, backup.Bh)
-		copy(rnn.by, backup.By)
-		copy(rnn.hprev, backup.Hprev)
-	}
-	return err
+	if err != nil {
+		return err
+	}
+	// The checkpoints that do not record the precision are in float64
+	if backup.Config.Precision == 0 {
+		backup.Config.Precision = 64
+	}
+	if err := checkPrecision(backup.Config.Precision); err != nil {
+		return err
+	}
+	if backup.Config.Precision == 32 {
+		net, err := restore32(backup)
+		rnn.net = net
+		return err
+	}
+	net := &network[float64]{
+		whh:    fromDense(backup.Whh),
+		why:    fromDense(backup.Why),
+		wxh:    fromDense(backup.Wxh),
+		wex:    fromDense(backup.Wex),
+		bh:     make([]float64, len(backup.Bh)),
+		by:     make([]float64, len(backup.By)),
+		hprev:  make([]float64, len(backup.Hprev)),
+		gain:   backup.Gain,
+		config: backup.Config,
+	}
+	copy(net.bh, backup.Bh)
+	copy(net.by, backup.By)
+	copy(net.hprev, backup.Hprev)
+	rnn.net = net
+	return nil
 }
 
 // GobEncode the RNN for backup
@@ -67,18 +113,48 @@ func (rnn *RNN) GobEncode() ([]byte, error) {
 	var output bytes.Buffer // Stand-in for a network connection
 
 	enc := gob.NewEncoder(&output) // Will write to network.
-	err := enc.Encode(bkp{
-		rnn.whh,
-		rnn.wxh,
-		rnn.why,
-		rnn.hprev,
-		rnn.bh,
-		rnn.by,
-		rnn.config,
-	})
+	err := enc.Encode(rnn.net.backup())
 	return output.Bytes(), err
 }
 
+// backup returns the checkpoint of the network
+func (rnn *network[T]) backup() bkp {
+	if net, ok := any(rnn).(*network[float64]); ok {
+		return bkp{
+			Wex:    toDense(net.wex),
+			Whh:    toDense(net.whh),
+			Wxh:    toDense(net.wxh),
+			Why:    toDense(net.why),
+			Hprev:  net.hprev,
+			Bh:     net.bh,
+			By:     net.by,
+			Gain:   net.gain,
+			Config: net.config,
+		}
+	}
+	return backup32(rnn)
+}
+
+// toDense returns a mat64 matrix that shares the data of m, or nil if m is nil
+func toDense(m *matrix[float64]) *mat64.Dense {
+	if m == nil {
+		return nil
+	}
+	return mat64.NewDense(m.rows, m.cols, m.data)
+}
+
+// fromDense returns a copy of the mat64 matrix d, or nil if d is nil
+func fromDense(d *mat64.Dense) *matrix[float64] {
+	if d == nil {
+		return nil
+	}
+	m := newMatrix[float64](d.Dims())
+	for i := 0; i < m.rows; i++ {
+		copy(m.row(i), d.RawRowView(i))
+	}
+	return m
+}
+
 // NewRNN creates a new RNN with input size of x, outputsize of y and hidden dimension of h
 // The hidden state h is initialized with the zero vectornn.
 //func newRNN(x, y, h int) *RNN {
@@ -95,39 +171,60 @@ func NewRNN(inputNeurons, outputNeurons int) *RNN {
 	if err != nil {
 		log.Fatal(err)
 	}
+	for _, groups := range [][]string{conf.DecayedParameters, conf.MaxNormParameters} {
+		if err := checkGroups(groups); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := checkPrecision(conf.Precision); err != nil {
+		log.Fatal(err)
+	}
 
 	//func NewRNN(config NeuralNetConfig) *RNN {
-	var rnn RNN
 	conf.InputNeurons = inputNeurons
 	conf.OutputNeurons = outputNeurons
-	rnn.config = conf
-	// Initialize biases/weights.
-
-	rnn.wxh = mat64.NewDense(conf.HiddenNeurons, conf.InputNeurons, nil)
-	rnn.whh = mat64.NewDense(conf.HiddenNeurons, conf.HiddenNeurons, nil)
-	rnn.why = mat64.NewDense(conf.OutputNeurons, conf.HiddenNeurons, nil)
-	rnn.bh = make([]float64, conf.HiddenNeurons)
-	rnn.by = make([]float64, conf.OutputNeurons)
-	wHiddenRaw := rnn.wxh.RawMatrix().Data
-	wHiddenHiddenRaw := rnn.whh.RawMatrix().Data
-	wOutRaw := rnn.why.RawMatrix().Data
-
-	for _, param := range [][]float64{
-		wHiddenRaw,
-		wHiddenHiddenRaw,
-		wOutRaw,
-	} {
-		for i := range param {
-			randSource := rand.NewSource(time.Now().UnixNano())
-			randGen := rand.New(randSource)
-			param[i] = randGen.NormFloat64() * conf.RandomFactor
-		}
+	if conf.Precision == 32 {
+		return &RNN{net: newNetwork[float32](conf).initialize()}
 	}
+	return &RNN{net: newNetwork[float64](conf).initialize()}
+}
 
-	// initialise the hidden vector to zero
-	rnn.hprev = make([]float64, conf.HiddenNeurons)
+// newNetwork returns a network with zero parameters shaped by the configuration
+func newNetwork[T float](conf neuralNetConfig) *network[T] {
+	p := newParameters[T](conf)
+	return &network[T]{
+		wex:    p.wex,
+		wxh:    p.wxh,
+		whh:    p.whh,
+		why:    p.why,
+		bh:     p.bh,
+		by:     p.by,
+		gain:   p.gain,
+		hprev:  make([]T, conf.HiddenNeurons),
+		config: conf,
+	}
+}
 
-	return &rnn
+// initialize the weights randomly and the gain of the layer normalization to one
+func (rnn *network[T]) initialize() *network[T] {
+	params := [][]T{
+		rnn.wxh.data,
+		rnn.whh.data,
+		rnn.why.data,
+	}
+	if rnn.wex != nil {
+		params = append(params, rnn.wex.data)
+	}
+	randGen := rnn.config.newRand(0)
+	for _, param := range params {
+		for i := range param {
+			param[i] = T(randGen.NormFloat64() * rnn.config.RandomFactor)
+		}
+	}
+	for i := range rnn.gain {
+		rnn.gain[i] = 1
+	}
+	return rnn
 }
 
 // RNNs have a deceptively simple API:
@@ -136,90 +233,267 @@ func NewRNN(inputNeurons, outputNeurons int) *RNN {
 // not only by the input you just fed in,
 // but also on the entire history of inputs you’ve fed in in the past.
 // Written as a class, the RNN’s API consists of a single step function:
-func (rnn *RNN) step(x, hprev []float64) (y, h []float64) {
-	h = tanh(
-		add(
-			dot(rnn.wxh, x),
-			dot(rnn.whh, hprev),
-			rnn.bh,
-		))
-	y = add(
-		dot(rnn.why, h),
-		rnn.by)
-	return
+// step feeds x to the network, updates the hidden state of the cell
+// and returns the normalized probabilities of the next element (they are overwritten by the next step)
+func (rnn *network[T]) step(c *cell[T], x []float64) []T {
+	convert(c.x, x)
+	in := c.x
+	if rnn.wex != nil {
+		mulVec(c.e, rnn.wex, in)
+		in = c.e
+	}
+	mulVec(c.a, rnn.wxh, in)
+	for i := range c.a {
+		c.a[i] += dot(rnn.whh.row(i), c.h)
+	}
+	if rnn.gain != nil {
+		layerNorm(c.a, c.n, rnn.gain)
+	}
+	for i, v := range c.a {
+		c.h[i] = T(math.Tanh(float64(v + rnn.bh[i])))
+	}
+	mulVec(c.p, rnn.why, c.h)
+	softmax(c.p, rnn.by)
+	return c.p
 }
 
-// forwardPass takes a matrix of inputs and returns
-// the corresponding outputs matrix
-// and a matrix of the hidden states that will be used
-// for the backpropagation
-func (rnn *RNN) forwardPass(xs [][]float64, hprev []float64) (ys, hs [][]float64) {
-	inputSize := len(xs)
-	// un-normalized log probabilities for next chars
-	ys = make([][]float64, inputSize)
-	hs = make([][]float64, inputSize)
-	for t := 0; t < inputSize; t++ {
-		// Initialization of the arrays
-		ys[t] = make([]float64, rnn.config.OutputNeurons)
-		hs[t] = make([]float64, rnn.config.HiddenNeurons)
-		ys[t], hs[t] = rnn.step(xs[t], hprev)
-		hprev = hs[t]
-	}
-	return
+// cell holds the hidden state of a sequence fed step by step and the buffers of the step
+type cell[T float] struct {
+	h []T // hidden state
+	p []T // probabilities of the next element
+	// input in the precision of the network, pre-activation, normalized pre-activation and embedding
+	x, a, n, e []T
+}
+
+// newCell returns a cell with a zero hidden state
+func (rnn *network[T]) newCell() *cell[T] {
+	return &cell[T]{
+		h: make([]T, rnn.config.HiddenNeurons),
+		p: make([]T, rnn.config.OutputNeurons),
+		x: make([]T, rnn.config.InputNeurons),
+		a: make([]T, rnn.config.HiddenNeurons),
+		n: make([]T, rnn.config.HiddenNeurons),
+		e: make([]T, rnn.config.EmbeddingSize),
+	}
+}
+
+// pass holds the values computed by a forward pass that are needed by the backpropagation
+type pass[T float] struct {
+	// ws holds the matrices of the pass and of its backpropagation
+	ws *workspace[T]
+	xs sequence[T]
+	// h0 holds the initial hidden states of the streams, one per row
+	h0 *matrix[T]
+	// es are the embeddings of the inputs, after the dropout (nil without embedding layer)
+	es []*matrix[T]
+	// hs and ps are the hidden states and the normalized probabilities
+	// of the streams at every time step
+	hs []*matrix[T]
+	ps []*matrix[T]
+	// With layer normalization, ns are the normalized pre-activations
+	// and sigmas their standard deviations, per stream
+	ns     []*matrix[T]
+	sigmas [][]T
+	// The dropout masks (nil without dropout): mi and mo are drawn at every time step
+	// for the inputs and the hidden to output connection; mr is drawn once for the recurrence
+	mi []*matrix[T]
+	mo []*matrix[T]
+	mr *matrix[T]
+}
+
+// matrices returns a slice of n matrices, reusing s if it is large enough
+func matrices[T float](s []*matrix[T], n int) []*matrix[T] {
+	if cap(s) < n {
+		return make([]*matrix[T], n)
+	}
+	return s[:n]
+}
+
+// forwardPass takes the inputs of a mini-batch of streams
+// and their initial hidden states h0, one per row.
+// The matrices of the pass are taken from the workspace ws, which is reset
+// (a new workspace is used if ws is nil).
+// The dropout masks are drawn from rnd; the dropout is disabled if rnd is nil
+func (rnn *network[T]) forwardPass(ws *workspace[T], xs sequence[T], h0 *matrix[T], rnd *rand.Rand) *pass[T] {
+	if ws == nil {
+		ws = &workspace[T]{}
+	}
+	ws.reset()
+	b, _ := h0.dims()
+	n := xs.len()
+	f := &ws.pass
+	f.ws = ws
+	f.xs = xs
+	f.h0 = h0
+	f.hs = matrices(f.hs, n)
+	f.ps = matrices(f.ps, n)
+	f.es, f.ns, f.sigmas, f.mi, f.mo, f.mr = f.es[:0], f.ns[:0], f.sigmas[:0], f.mi[:0], f.mo[:0], nil
+	if rnn.wex != nil {
+		f.es = matrices(f.es, n)
+	}
+	if rnn.gain != nil {
+		f.ns = matrices(f.ns, n)
+		if cap(f.sigmas) < n {
+			f.sigmas = make([][]T, n)
+		}
+		f.sigmas = f.sigmas[:n]
+	}
+	if rnd != nil {
+		f.mr = ws.dropoutMask(rnd, b, rnn.config.HiddenNeurons, rnn.config.RecurrentDropout)
+		if rnn.config.InputDropout > 0 {
+			f.mi = matrices(f.mi, n)
+		}
+		if rnn.config.OutputDropout > 0 {
+			f.mo = matrices(f.mo, n)
+		}
+	}
+	hprev := ws.dropped(h0, f.mr)
+	for t := range f.hs {
+		var mi *matrix[T]
+		if len(f.mi) > 0 {
+			mi = ws.dropoutMask(rnd, b, rnn.config.inputSize(), rnn.config.InputDropout)
+			f.mi[t] = mi
+		}
+		h := ws.matrix(b, rnn.config.HiddenNeurons)
+		if rnn.wex != nil {
+			e := ws.matrix(b, rnn.config.EmbeddingSize)
+			xs.mulT(ws, e, t, rnn.wex, nil)
+			if mi != nil {
+				mulElem(e.data, mi.data)
+			}
+			mulT(h, e, rnn.wxh)
+			f.es[t] = e
+		} else {
+			xs.mulT(ws, h, t, rnn.wxh, mi)
+		}
+		addMulT(h, hprev, rnn.whh)
+		if rnn.gain != nil {
+			f.ns[t] = ws.matrix(b, rnn.config.HiddenNeurons)
+			f.sigmas[t] = ws.vector(b)
+			for i := 0; i < b; i++ {
+				f.sigmas[t][i] = layerNorm(h.row(i), f.ns[t].row(i), rnn.gain)
+			}
+		}
+		for i := 0; i < b; i++ {
+			row := h.row(i)
+			for j, v := range row {
+				row[j] = T(math.Tanh(float64(v + rnn.bh[j])))
+			}
+		}
+		ho := h
+		if len(f.mo) > 0 {
+			f.mo[t] = ws.dropoutMask(rnd, b, rnn.config.HiddenNeurons, rnn.config.OutputDropout)
+			ho = ws.dropped(h, f.mo[t])
+		}
+		y := ws.matrix(b, rnn.config.OutputNeurons)
+		mulT(y, ho, rnn.why)
+		for i := 0; i < b; i++ {
+			softmax(y.row(i), rnn.by)
+		}
+		f.ps[t] = y
+		f.hs[t] = h
+		hprev = ws.dropped(h, f.mr)
+	}
+	return f
 }
 
 // Do a backpropagation of the RNNs and returns the derivates
-// xs is the input mat64rix
-// ts is the target mat64rices
-// ps is the normalized log probability
-// hs is a mat64rix of hidden vector
-func (rnn *RNN) backPropagation(xs, ps, hs, ts [][]float64) (dwxh, dwhh, dwhy *mat64.Dense, dbh, dby []float64) {
-	inputSize := len(xs)
-	dwxh = mat64.NewDense(rnn.config.HiddenNeurons, rnn.config.InputNeurons, nil)
-	dwhh = mat64.NewDense(rnn.config.HiddenNeurons, rnn.config.HiddenNeurons, nil)
-	dwhy = mat64.NewDense(rnn.config.OutputNeurons, rnn.config.HiddenNeurons, nil)
-	dhnext := make([]float64, rnn.config.OutputNeurons)
-	dbh = make([]float64, rnn.config.HiddenNeurons)
-	dby = make([]float64, rnn.config.OutputNeurons)
-	dhraw := make([]float64, len(hs[0]))
-
-	for t := inputSize - 1; t >= 0; t-- {
-		dy := make([]float64, rnn.config.OutputNeurons)
-		for i := range ps[t] {
-			dy[i] = ps[t][i] - ts[t][i]
+// averaged over the streams of the mini-batch
+// f is the forward pass
+// ts is the target matrices
+// Only the errors of the outputs from the time step from are backpropagated.
+// The derivates belong to the workspace of the pass: they are valid until its next forward pass
+func (rnn *network[T]) backPropagation(f *pass[T], ts sequence[T], from int) *parameters[T] {
+	ws := f.ws
+	b, _ := f.h0.dims()
+	g := ws.gradients(rnn.config)
+	dhnext := ws.matrix(b, rnn.config.HiddenNeurons)
+	dy := ws.matrix(b, rnn.config.OutputNeurons)
+	dh := ws.matrix(b, rnn.config.HiddenNeurons)
+	var de *matrix[T]
+	if rnn.wex != nil {
+		de = ws.matrix(b, rnn.config.EmbeddingSize)
+	}
+
+	for t := len(f.ps) - 1; t >= 0; t-- {
+		var mi, mo *matrix[T]
+		if len(f.mi) > 0 {
+			mi = f.mi[t]
+		}
+		if len(f.mo) > 0 {
+			mo = f.mo[t]
 		}
-		dwhy.Add(dwhy,
-			dotVec(dy, hs[t]),
-		)
-		dby = add(dby, dy)
-
-		dh := add(
-			dot(rnn.why.T(), dy),
-			dhnext,
-		)
-
-		for i := range hs[t] {
-			dhraw[i] = (1 - hs[t][i]*hs[t][i]) * dh[i]
+		if t >= from {
+			ts.sub(dy, f.ps[t], t)
+			scale(dy.data, T(1/float64(b)))
+			addTMul(g.why, dy, ws.dropped(f.hs[t], mo))
+			addRows(g.by, dy)
+
+			mul(dh, dy, rnn.why)
+			if mo != nil {
+				mulElem(dh.data, mo.data)
+			}
+			axpy(dh.data, 1, dhnext.data)
+		} else {
+			copy(dh.data, dhnext.data)
+		}
+		// Backprop through tanh
+		h := f.hs[t]
+		for i := 0; i < b; i++ {
+			hrow := h.row(i)
+			row := dh.row(i)
+			for j, v := range row {
+				row[j] = (1 - hrow[j]*hrow[j]) * v
+			}
 		}
 
-		dbh = add(dbh, dhraw)
-		dwxh.Add(dwxh, dotVec(dhraw, xs[t]))
-		if t == 0 {
-			dwhh.Add(dwhh, dotVec(dhraw, hs[inputSize-1]))
+		addRows(g.bh, dh)
+		if rnn.gain != nil {
+			for i := 0; i < b; i++ {
+				layerNormBackward(dh.row(i), f.ns[t].row(i), rnn.gain, g.gain, f.sigmas[t][i])
+			}
+		}
+		if rnn.wex != nil {
+			addTMul(g.wxh, dh, f.es[t])
+			mul(de, dh, rnn.wxh)
+			if mi != nil {
+				mulElem(de.data, mi.data)
+			}
+			f.xs.addOuter(ws, g.wex, de, t, nil)
 		} else {
-			dwhh.Add(dwhh, dotVec(dhraw, hs[t-1]))
+			f.xs.addOuter(ws, g.wxh, dh, t, mi)
+		}
+		hprev := f.h0
+		if t > 0 {
+			hprev = f.hs[t-1]
+		}
+		addTMul(g.whh, dh, ws.dropped(hprev, f.mr))
+		mul(dhnext, dh, rnn.whh)
+		if f.mr != nil {
+			mulElem(dhnext.data, f.mr.data)
 		}
-		dhnext = dot(rnn.whh.T(), dhraw)
 	}
 
-	return
+	return g
 }
 
-// TrainingSet represents an input mat64rix and the expected
+// TrainingSet represents an input matrix and the expected
 // result when passed through a rnn
 type TrainingSet struct {
 	Inputs  [][]float64
 	Targets [][]float64
+	// Reset the hidden state before the training because
+	// the set does not follow the previous one in the corpus
+	Reset bool
+	// InputIndexes and TargetIndexes are the indexes of the elements of one-hot encoded inputs and targets.
+	// When they are set, Inputs and Targets are ignored: the product of an input by wxh is a lookup of a column
+	InputIndexes  []int
+	TargetIndexes []int
+	// Streams holds the independent sequences of a mini-batch, all of the same length.
+	// Every stream has its own hidden state, carried from a TrainingSet to the next one
+	// according to its position in Streams, and the gradients are averaged over the streams.
+	// When Streams is set, the other fields are ignored
+	Streams []TrainingSet
 }
 
 // CopyOf the trainingset passed as parameter
@@ -228,108 +502,138 @@ func CopyOf(tset TrainingSet) TrainingSet {
 	copy(xs, tset.Inputs)
 	ts := make([][]float64, len(tset.Targets))
 	copy(ts, tset.Targets)
+	var streams []TrainingSet
+	if tset.Streams != nil {
+		streams = make([]TrainingSet, len(tset.Streams))
+		for i, s := range tset.Streams {
+			streams[i] = CopyOf(s)
+		}
+	}
 	return TrainingSet{
-		xs,
-		ts,
+		Inputs:        xs,
+		Targets:       ts,
+		InputIndexes:  copyOfIndexes(tset.InputIndexes),
+		TargetIndexes: copyOfIndexes(tset.TargetIndexes),
+		Reset:         tset.Reset,
+		Streams:       streams,
 	}
 }
 
+func copyOfIndexes(ixs []int) []int {
+	if ixs == nil {
+		return nil
+	}
+	return append([]int{}, ixs...)
+}
+
 // Train the network.
 // The train mechanisme is launched in a seperate go-routine
 // it is waiting for an input to be sent in the feeding channel
+// the info channel is closed once the feeding channel is closed and the training is over
 func (rnn *RNN) Train() (chan<- TrainingSet, <-chan float64) {
+	return rnn.net.train()
+}
+
+// train implements RNN.Train
+func (rnn *network[T]) train() (chan<- TrainingSet, <-chan float64) {
+	if rnn.config.Workers > 1 && rnn.config.Asynchronous {
+		return rnn.trainHogwild(rnn.config.Workers)
+	}
+	if rnn.config.Workers > 1 {
+		return rnn.trainParallel(rnn.config.Workers)
+	}
 	feed := make(chan TrainingSet, 1)
 	info := make(chan float64, 1)
 
-	adagrad := newAdagrad(rnn.config)
+	opt := newOptimizer[T](rnn.config)
+	// The hidden state of the first stream is rnn.hprev
+	w := rnn.newWorker(0)
+	w.hprevs = [][]T{rnn.hprev}
 	go func(feed <-chan TrainingSet, info chan<- float64) {
+		defer close(info)
 		// When we have new data
-		//for tset := range feed {
 		for tset := range feed {
-			// Forward pass
-			xs := tset.Inputs
-			ts := tset.Targets
-			hp := make([]float64, len(rnn.hprev))
-			copy(hp, rnn.hprev)
-			ys, hs := rnn.forwardPass(xs, hp)
-			// Save the last state for future training
-			copy(rnn.hprev, hs[len(hs)-1])
-			//rnn.hprev = hs[len(hs)-1]
-			ps := normalizeByRow(ys)
-			// Loss evaluation
-			loss := float64(0)
-			for t := 0; t < len(ps); t++ {
-				l := float64(0)
-				for i := 0; i < len(ps[t]); i++ {
-					l += ps[t][i] * ts[t][i]
-				}
-				loss -= math.Log(l)
-			}
+			p := w.start(rnn, tset)
+			loss := rnn.trainSequence(p, w.rnd, opt)
+			// Save the last states for future training
+			w.save(p)
 			// Send info on a non blocking channel
 			select {
 			case info <- loss:
 			default:
 			}
-
-			// Backpass
-			dwxh, dwhh, dwhy, dbh, dby := rnn.backPropagation(xs, ps, hs, ts)
-			// Clip to mitigate exploding gradients
-			for _, param := range [][]float64{
-				dwxh.RawMatrix().Data,
-				dwhh.RawMatrix().Data,
-				dwhy.RawMatrix().Data,
-				dby,
-				dbh,
-			} {
-				func(param []float64) {
-					for i := range param {
-						if param[i] > 1 {
-							param[i] = 1
-						}
-						if param[i] < -1 {
-							param[i] = -1
-						}
-					}
-				}(param)
-			}
-			// Adaptation
-			adagrad.apply(rnn, dwxh, dwhh, dwhy, dbh, dby)
 		}
+		opt.flush(rnn)
 	}(feed, info)
 	return feed, info
 }
 
 // Predict n element of  output that corresponds to the input xs
 // At every iteration, the output is processed by the adapt function
-func (rnn *RNN) Predict(xs [][]float64, n int, adapt func([]float64) []float64) [][]float64 {
-	ys := make([][]float64, n+len(xs))
-	h := make([]float64, len(rnn.hprev))
-	y := make([]float64, rnn.config.OutputNeurons)
+// once the filters have been applied to the probability distribution
+func (rnn *RNN) Predict(xs [][]float64, n int, adapt func([]float64) []float64, filters ...Filter) [][]float64 {
+	return rnn.net.predict(xs, n, adapt, filters...)
+}
+
+// predict implements RNN.Predict
+func (rnn *network[T]) predict(xs [][]float64, n int, adapt func([]float64) []float64, filters ...Filter) [][]float64 {
+	ys := make([][]float64, 0, n)
+	history := make([][]float64, len(xs), n+len(xs))
+	copy(history, xs)
+	c := rnn.newCell()
+	// p is the distribution of the next element, reused from a step to the next
+	p := make([]float64, rnn.config.OutputNeurons)
+	// y is the last output, fed back to the network
+	var y []float64
 	for i := 0; i < n+len(xs); i++ {
-		x := make([]float64, rnn.config.InputNeurons)
+		x := y
 		if i < len(xs) {
-			copy(x, xs[i])
-		} else {
-			copy(x, ys[i-1])
+			x = xs[i]
 		}
-
-		yr, hr := rnn.step(x, h)
-		copy(y, yr)
-		copy(h, hr)
-		expY := exp(y)
-		p := div(expY, sum(expY))
-		ys[i] = p
+		convert(p, rnn.step(c, x))
 		if i < len(xs) {
-			for j := 0; j < len(xs[i]); j++ {
-				if xs[i][j] == float64(1) {
-					ys[i][j] = float64(1)
+			// Only the output of the last element of xs is fed back
+			if i == len(xs)-1 {
+				y = append([]float64(nil), p...)
+				for j := 0; j < len(xs[i]); j++ {
+					if xs[i][j] == float64(1) {
+						y[j] = float64(1)
+					}
 				}
 			}
-		} else {
-			ys[i] = adapt(p)
+			continue
+		}
+		for _, filter := range filters {
+			filter(p, history)
+		}
+		y = adapt(p)
+		if len(y) > 0 && &y[0] == &p[0] {
+			// The output must outlive the buffer
+			y = append([]float64(nil), y...)
+		}
+		ys = append(ys, y)
+		history = append(history, y)
+	}
+	return ys
+}
+
+// Embeddings returns the learned embedding of every input element,
+// or nil if the embedding layer is disabled
+func (rnn *RNN) Embeddings() [][]float64 {
+	return rnn.net.embeddings()
+}
+
+// embeddings implements RNN.Embeddings
+func (rnn *network[T]) embeddings() [][]float64 {
+	if rnn.wex == nil {
+		return nil
+	}
+	embeddings := make([][]float64, rnn.config.InputNeurons)
+	for ix := range embeddings {
+		embeddings[ix] = make([]float64, rnn.config.EmbeddingSize)
+		for k := range embeddings[ix] {
+			embeddings[ix][k] = float64(rnn.wex.at(k, ix))
 		}
 	}
-	res := make([][]float64, n)
-	copy(res, ys[len(xs):])
-	return res
+	return embeddings
 }
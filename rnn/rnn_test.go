@@ -1,14 +1,42 @@
 package rnn
 
 import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
 	"testing"
-
-	"github.com/gonum/matrix/mat64"
 )
 
+// network64 returns a new network of 64 bits precision
+func network64(inputNeurons, outputNeurons int) *network[float64] {
+	return NewRNN(inputNeurons, outputNeurons).net.(*network[float64])
+}
+
+// equal reports whether the matrices a and b are equal within tol
+func equal(a, b *matrix[float64], tol float64) bool {
+	if a.rows != b.rows || a.cols != b.cols {
+		return false
+	}
+	for i := range a.data {
+		if math.Abs(a.data[i]-b.data[i]) > tol {
+			return false
+		}
+	}
+	return true
+}
+
+// duplicate returns a copy of the matrix m
+func duplicate(m *matrix[float64]) *matrix[float64] {
+	return &matrix[float64]{rows: m.rows, cols: m.cols, data: append([]float64(nil), m.data...)}
+}
+
 func TestGob(t *testing.T) {
 	// Create a new RNN
-	rnn := NewRNN(5, 5)
+	rnn := network64(5, 5)
 	rnn.by[1] = 1.0
 	rnn.by[2] = 2.0
 	rnn.by[3] = 3.0
@@ -21,22 +49,23 @@ func TestGob(t *testing.T) {
 	rnn.hprev[2] = 2.0
 	rnn.hprev[3] = 3.0
 	rnn.hprev[4] = 4.0
-	b, err := rnn.GobEncode()
+	b, err := (&RNN{net: rnn}).GobEncode()
 	if err != nil {
 		t.Fatal(err)
 	}
-	rnnBkp := NewRNN(1, 1)
-	err = rnnBkp.GobDecode(b)
+	r := NewRNN(1, 1)
+	err = r.GobDecode(b)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !mat64.Equal(rnn.whh, rnnBkp.whh) {
+	rnnBkp := r.net.(*network[float64])
+	if !equal(rnn.whh, rnnBkp.whh, 0) {
 		t.Fatal("whh differs")
 	}
-	if !mat64.Equal(rnn.wxh, rnnBkp.wxh) {
+	if !equal(rnn.wxh, rnnBkp.wxh, 0) {
 		t.Fatal("wxh differs")
 	}
-	if !mat64.Equal(rnn.why, rnnBkp.why) {
+	if !equal(rnn.why, rnnBkp.why, 0) {
 		t.Fatal("why differs")
 	}
 	if !testEq(rnn.bh, rnnBkp.bh) {
@@ -72,3 +101,948 @@ func testEq(a, b []float64) bool {
 
 	return true
 }
+
+// The output layer may be larger than the hidden layer (100 neurons by default)
+func TestTrainShapes(t *testing.T) {
+	rnn := NewRNN(3, 120)
+	tset := TrainingSet{Inputs: [][]float64{{1, 0, 0}, {0, 1, 0}}}
+	for i := range tset.Inputs {
+		target := make([]float64, 120)
+		target[i] = 1
+		tset.Targets = append(tset.Targets, target)
+	}
+	feed, info := rnn.Train()
+	// The second loss is sent once the first TrainingSet is backpropagated
+	for i := 0; i < 2; i++ {
+		feed <- tset
+		<-info
+	}
+	close(feed)
+}
+
+func TestEvaluate(t *testing.T) {
+	rnn := network64(3, 3)
+	xs := [][]float64{
+		{1, 0, 0},
+		{0, 1, 0},
+		{0, 0, 1},
+	}
+	ev := rnn.evaluate(nil, xs)
+	if len(ev.LogProbs) != 2 || ev.Offset != 1 {
+		t.Fatalf("the first element should not be scored without prefix: %v from %v", ev.LogProbs, ev.Offset)
+	}
+	ll := float64(0)
+	for _, l := range ev.LogProbs {
+		if l > 0 {
+			t.Fatalf("bad log-probability %v", l)
+		}
+		ll += l
+	}
+	if math.Abs(ll-ev.LogLikelihood) > 1e-12 {
+		t.Fatalf("bad log-likelihood: expected %v, got %v", ll, ev.LogLikelihood)
+	}
+	if math.Abs(ev.Perplexity-math.Exp(-ll/2)) > 1e-12 {
+		t.Fatalf("bad perplexity %v", ev.Perplexity)
+	}
+	ev = rnn.evaluate(xs[:1], xs[1:])
+	if len(ev.LogProbs) != 2 || ev.Offset != 0 || ev.LogProbs[0] == 0 {
+		t.Fatal("the prefix should condition the first element")
+	}
+	// The probability of the last element underflows
+	rnn.by[2] = -1e4
+	ev = rnn.evaluate(nil, xs)
+	if math.IsInf(ev.LogProbs[1], 0) || ev.LogProbs[1] != math.Log(minProbability) {
+		t.Fatalf("the log-probability of an impossible element should be clamped, got %v", ev.LogProbs[1])
+	}
+	if _, err := json.Marshal(ev); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConstrain(t *testing.T) {
+	p := []float64{0.5, 0.2, 0.3}
+	Mask([]bool{false, true, true})(p, nil)
+	if p[0] != 0 || math.Abs(p[1]-0.4) > 1e-12 || math.Abs(p[2]-0.6) > 1e-12 {
+		t.Fatalf("bad renormalization: %v", p)
+	}
+	p = []float64{1, 0, 0}
+	Mask([]bool{false, true, true})(p, nil)
+	if p[0] != 0 || p[1] != 0.5 || p[2] != 0.5 {
+		t.Fatalf("allowed entries should be equally likely: %v", p)
+	}
+}
+
+func TestBlockNgrams(t *testing.T) {
+	a := []float64{1, 0, 0}
+	b := []float64{0, 1, 0}
+	// history is a b a: the next b would repeat the bigram "a b"
+	p := []float64{0.2, 0.6, 0.2}
+	BlockNgrams(2)(p, [][]float64{a, b, a})
+	if p[1] != 0 || p[0] != 0.5 || p[2] != 0.5 {
+		t.Fatalf("bad blocking: %v", p)
+	}
+}
+
+// randomize the parameters of the rnn with larger weights than the initialization
+func randomize(rnn *network[float64], rnd *rand.Rand) {
+	for _, param := range rnn.params().raw() {
+		for i := range param {
+			param[i] = rnd.NormFloat64() * 0.3
+		}
+	}
+}
+
+// randomBatch returns a mini-batch of b streams of n one-hot encoded elements
+func randomBatch(rnd *rand.Rand, b, n, k int) TrainingSet {
+	var tset TrainingSet
+	for i := 0; i < b; i++ {
+		xs := make([][]float64, n+1)
+		for t := range xs {
+			xs[t] = make([]float64, k)
+			xs[t][rnd.Intn(k)] = 1
+		}
+		tset.Streams = append(tset.Streams, TrainingSet{Inputs: xs[:n], Targets: xs[1:]})
+	}
+	return tset
+}
+
+// sparseOf returns the mini-batch with the indexes of the one-hot vectors
+func sparseOf(dense TrainingSet) TrainingSet {
+	var sparse TrainingSet
+	for _, s := range dense.Streams {
+		var stream TrainingSet
+		for i := range s.Inputs {
+			stream.InputIndexes = append(stream.InputIndexes, argmax(s.Inputs[i]))
+			stream.TargetIndexes = append(stream.TargetIndexes, argmax(s.Targets[i]))
+		}
+		sparse.Streams = append(sparse.Streams, stream)
+	}
+	return sparse
+}
+
+// checkGradients compares the gradients computed by the backpropagation
+// to the numerical derivatives of the loss of the mini-batch
+// Only the outputs from the time step from count in the loss;
+// seed is the seed of the dropout masks (0 disables the dropout)
+func checkGradients(t *testing.T, rnn *network[float64], rnd *rand.Rand, tset TrainingSet, from int, seed int64) {
+	xs, ts := minibatch[float64](tset.Streams)
+	b := len(tset.Streams)
+	// A non-zero initial state checks the gradient of whh at the first step
+	h0 := newMatrix[float64](b, rnn.config.HiddenNeurons)
+	for i := range h0.data {
+		h0.data[i] = rnd.Float64()*2 - 1
+	}
+	// The same masks are drawn at every forward pass
+	dropout := func() *rand.Rand {
+		if seed == 0 {
+			return nil
+		}
+		return rand.New(rand.NewSource(seed))
+	}
+	loss := func() float64 {
+		return crossEntropy(rnn.forwardPass(nil, xs, h0, dropout()).ps[from:], ts.slice(from, ts.len())) / float64(b)
+	}
+	grads := rnn.backPropagation(rnn.forwardPass(nil, xs, h0, dropout()), ts, from).raw()
+	for k, param := range rnn.params().raw() {
+		grad := grads[k]
+		for n := 0; n < 20; n++ {
+			i := rnd.Intn(len(param))
+			const eps = 1e-5
+			v := param[i]
+			param[i] = v + eps
+			plus := loss()
+			param[i] = v - eps
+			minus := loss()
+			param[i] = v
+			numerical := (plus - minus) / (2 * eps)
+			if math.Abs(numerical-grad[i]) > 1e-6*math.Max(1, math.Abs(numerical)) {
+				t.Errorf("parameter %v[%v]: expected a gradient of %v, got %v", k, i, numerical, grad[i])
+			}
+		}
+	}
+}
+
+func TestGradients(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	tset := randomBatch(rnd, 3, 4, 5)
+	checkGradients(t, rnn, rnd, tset, 0, 0)
+	checkGradients(t, rnn, rnd, sparseOf(tset), 0, 0)
+}
+
+func TestEmbeddings(t *testing.T) {
+	if network64(5, 5).wex != nil {
+		t.Fatal("the embedding layer should be disabled by default")
+	}
+	os.Setenv("RNN_EMBEDDINGSIZE", "3")
+	defer os.Unsetenv("RNN_EMBEDDINGSIZE")
+	rnd := rand.New(rand.NewSource(1))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	tset := randomBatch(rnd, 3, 4, 5)
+	checkGradients(t, rnn, rnd, tset, 0, 0)
+	checkGradients(t, rnn, rnd, sparseOf(tset), 0, 0)
+	e := rnn.embeddings()
+	if len(e) != 5 || len(e[0]) != 3 || e[4][2] != rnn.wex.at(2, 4) {
+		t.Fatalf("expected 5 embeddings of size 3, got %v", e)
+	}
+	if !equal(rnn.wex, clone(t, rnn).wex, 0) {
+		t.Fatal("wex differs")
+	}
+}
+
+func TestTrainStreams(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	rnn := network64(5, 5)
+	tset := randomBatch(rnd, 4, 10, 5)
+	// The same sequences are learned again and again from a zero state
+	for i := range tset.Streams {
+		tset.Streams[i].Reset = true
+	}
+	feed, info := rnn.train()
+	var first, last float64
+	for i := 0; i < 100; i++ {
+		feed <- tset
+		l := <-info
+		if i == 0 {
+			first = l
+		}
+		last = l
+	}
+	close(feed)
+	if last >= first {
+		t.Fatalf("the loss of the mini-batch should decrease: %v then %v", first, last)
+	}
+}
+
+func TestSparse(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	dense := randomBatch(rnd, 3, 4, 5)
+	h0 := newMatrix[float64](3, rnn.config.HiddenNeurons)
+	var losses []float64
+	var grads [][][]float64
+	for _, tset := range []TrainingSet{dense, sparseOf(dense)} {
+		xs, ts := minibatch[float64](tset.Streams)
+		f := rnn.forwardPass(nil, xs, h0, nil)
+		losses = append(losses, crossEntropy(f.ps, ts))
+		grads = append(grads, rnn.backPropagation(f, ts, 0).raw())
+	}
+	if math.Abs(losses[0]-losses[1]) > 1e-12 {
+		t.Fatalf("the dense and sparse losses differ: %v and %v", losses[0], losses[1])
+	}
+	for k := range grads[0] {
+		for i := range grads[0][k] {
+			if math.Abs(grads[0][k][i]-grads[1][k][i]) > 1e-12 {
+				t.Fatalf("the dense and sparse gradients differ at %v[%v]: %v and %v", k, i, grads[0][k][i], grads[1][k][i])
+			}
+		}
+	}
+}
+
+func TestDropout(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	for _, embedding := range []int{0, 3} {
+		rnn := network64(5, 5)
+		rnn.config.InputDropout = 0.3
+		rnn.config.OutputDropout = 0.3
+		rnn.config.RecurrentDropout = 0.3
+		if embedding > 0 {
+			rnn.config.EmbeddingSize = embedding
+			rnn.wxh = newMatrix[float64](rnn.config.HiddenNeurons, embedding)
+			rnn.wex = newMatrix[float64](embedding, 5)
+		}
+		randomize(rnn, rnd)
+		tset := randomBatch(rnd, 3, 4, 5)
+		checkGradients(t, rnn, rnd, tset, 0, 42)
+		checkGradients(t, rnn, rnd, sparseOf(tset), 0, 42)
+		// The dropout is disabled in the evaluation
+		xs := tset.Streams[0].Inputs
+		if rnn.evaluate(nil, xs).LogLikelihood != rnn.evaluate(nil, xs).LogLikelihood {
+			t.Fatal("the evaluation should be deterministic")
+		}
+	}
+}
+
+func TestWeightDecay(t *testing.T) {
+	rnd := rand.New(rand.NewSource(4))
+	rnn := network64(5, 5)
+	if len(rnn.config.DecayedParameters) != 4 || rnn.config.MaxNormParameters[0] != "whh" {
+		t.Fatalf("unexpected default groups %v %v", rnn.config.DecayedParameters, rnn.config.MaxNormParameters)
+	}
+	randomize(rnn, rnd)
+	rnn.config.WeightDecay = 0.5
+	// Coupled: the gradient of the decay is added to the gradients of the weights, not of the biases
+	g := newParameters[float64](rnn.config)
+	rnn.decay(g)
+	for i, name := range []string{"wxh", "whh", "why", "bh", "by"} {
+		w, _, _ := rnn.params().group(name)
+		d := g.raw()[i]
+		for j := range d {
+			expected := 0.5 * w[j]
+			if i > 2 {
+				expected = 0
+			}
+			if d[j] != expected {
+				t.Fatalf("%v: expected %v, got %v", name, expected, d[j])
+			}
+		}
+	}
+	// Decoupled: the weights shrink after the adaptation
+	rnn.config.DecoupledWeightDecay = true
+	g = newParameters[float64](rnn.config)
+	rnn.decay(g)
+	if g.whh.at(0, 0) != 0 {
+		t.Fatal("the decoupled decay should not change the gradients")
+	}
+	whh, bh := rnn.whh.at(1, 2), rnn.bh[0]
+	rnn.regularize()
+	if expected := whh * (1 - rnn.config.LearningRate*0.5); math.Abs(rnn.whh.at(1, 2)-expected) > 1e-12 {
+		t.Fatalf("expected %v, got %v", expected, rnn.whh.at(1, 2))
+	}
+	if rnn.bh[0] != bh {
+		t.Fatal("the biases should not decay by default")
+	}
+}
+
+func TestMaxNorm(t *testing.T) {
+	rnd := rand.New(rand.NewSource(5))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	scale(rnn.whh.data, 100)
+	scale(rnn.wxh.data, 100)
+	rnn.config.MaxNorm = 2
+	wxh := duplicate(rnn.wxh)
+	rnn.regularize()
+	for i := 0; i < rnn.whh.rows; i++ {
+		if n := math.Sqrt(dot(rnn.whh.row(i), rnn.whh.row(i))); n > 2+1e-9 {
+			t.Fatalf("row %v has the norm %v", i, n)
+		}
+	}
+	if !equal(wxh, rnn.wxh, 0) {
+		t.Fatal("only whh is constrained by default")
+	}
+	if err := checkGroups([]string{"whh", "wyh"}); err == nil {
+		t.Fatal("wyh is not a parameter group")
+	}
+}
+
+func TestGroupValues(t *testing.T) {
+	rnd := rand.New(rand.NewSource(6))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	scale(rnn.why.data, 100)
+	rnn.config.WeightDecay = 0.5
+	rnn.config.DecayedParameters = []string{"wxh", "whh:0.1", "why:0"}
+	// The groups without a value are decayed by WeightDecay
+	g := newParameters[float64](rnn.config)
+	rnn.decay(g)
+	for name, wd := range map[string]float64{"wxh": 0.5, "whh": 0.1, "why": 0} {
+		w, _, _ := rnn.params().group(name)
+		d, _, _ := g.group(name)
+		for j := range d {
+			if d[j] != wd*w[j] {
+				t.Fatalf("%v: expected a decay of %v", name, wd)
+			}
+		}
+	}
+	// MaxNorm is disabled, but why has its own max-norm
+	rnn.config.DecayedParameters = nil
+	rnn.config.MaxNormParameters = []string{"why:3"}
+	rnn.regularize()
+	for i := 0; i < rnn.why.rows; i++ {
+		if n := math.Sqrt(dot(rnn.why.row(i), rnn.why.row(i))); n > 3+1e-9 {
+			t.Fatalf("row %v has the norm %v", i, n)
+		}
+	}
+	for _, groups := range [][]string{{"whh:x"}, {"wyh:1"}} {
+		if err := checkGroups(groups); err == nil {
+			t.Fatalf("%v should be rejected", groups)
+		}
+	}
+	if err := checkGroups([]string{"whh:1e-4", "why:0", "bh"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLayerNorm(t *testing.T) {
+	if network64(5, 5).gain != nil {
+		t.Fatal("the layer normalization should be disabled by default")
+	}
+	os.Setenv("RNN_LAYERNORM", "true")
+	defer os.Unsetenv("RNN_LAYERNORM")
+	rnd := rand.New(rand.NewSource(6))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	for i := range rnn.gain {
+		rnn.gain[i] += 1
+	}
+	tset := randomBatch(rnd, 3, 4, 5)
+	checkGradients(t, rnn, rnd, tset, 0, 0)
+	checkGradients(t, rnn, rnd, sparseOf(tset), 0, 0)
+	rnn.config.RecurrentDropout = 0.3
+	checkGradients(t, rnn, rnd, sparseOf(tset), 0, 42)
+	// The step of the prediction computes the same hidden states as the training
+	s := tset.Streams[0]
+	h0 := newMatrix[float64](1, rnn.config.HiddenNeurons)
+	xs, _ := minibatch[float64]([]TrainingSet{s})
+	f := rnn.forwardPass(nil, xs, h0, nil)
+	c := rnn.newCell()
+	for i, x := range s.Inputs {
+		rnn.step(c, x)
+		h := c.h
+		for j := range h {
+			if math.Abs(h[j]-f.hs[i].at(0, j)) > 1e-12 {
+				t.Fatalf("step %v: expected %v, got %v", i, f.hs[i].row(0), h)
+			}
+		}
+	}
+	if !testEq(rnn.gain, clone(t, rnn).gain) {
+		t.Fatal("gain differs")
+	}
+}
+
+// clone returns a copy of the rnn by a gob round trip
+func clone[T float](t *testing.T, rnn *network[T]) *network[T] {
+	b, err := (&RNN{net: rnn}).GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c RNN
+	if err := c.GobDecode(b); err != nil {
+		t.Fatal(err)
+	}
+	return c.net.(*network[T])
+}
+
+func TestTruncatedBPTT(t *testing.T) {
+	rnd := rand.New(rand.NewSource(7))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	tset := randomBatch(rnd, 3, 6, 5)
+	// Only the errors of the last outputs are backpropagated
+	checkGradients(t, rnn, rnd, tset, 4, 0)
+	checkGradients(t, rnn, rnd, sparseOf(tset), 2, 0)
+
+	if k1, k2 := rnn.config.truncation(25); k1 != 25 || k2 != 25 {
+		t.Fatalf("the whole sequence should be backpropagated by default, got %v and %v", k1, k2)
+	}
+	// Without truncation, trainSequence does a single update
+	xs, ts := minibatch[float64](tset.Streams)
+	h0 := newMatrix[float64](3, rnn.config.HiddenNeurons)
+	expected := clone(t, rnn)
+	f := expected.forwardPass(nil, xs, h0, nil)
+	g := expected.backPropagation(f, ts, 0)
+	g.clip(1)
+	newAdagrad[float64](expected.config).apply(expected, g)
+	c := clone(t, rnn)
+	p := c.newBPTT(xs, ts, h0)
+	loss := c.trainSequence(p, nil, newOptimizer[float64](rnn.config))
+	h := p.last()
+	if math.Abs(loss-crossEntropy(f.ps, ts)/3) > 1e-12 || !equal(h, f.hs[5], 0) {
+		t.Fatalf("unexpected loss %v or last states", loss)
+	}
+	trained := clone(t, rnn)
+	trained.trainSequence(trained.newBPTT(xs, ts, h0), nil, newOptimizer[float64](rnn.config))
+	if !equal(trained.whh, expected.whh, 0) {
+		t.Fatal("the parameters differ after a single update")
+	}
+
+	// A long sequence is learned by updates every 5 steps over 10 steps
+	rnn.config.UpdateSteps = 5
+	rnn.config.BackpropSteps = 10
+	long := randomBatch(rnd, 2, 40, 5)
+	xs, ts = minibatch[float64](long.Streams)
+	h0 = newMatrix[float64](2, rnn.config.HiddenNeurons)
+	a := newOptimizer[float64](rnn.config)
+	first := rnn.trainSequence(rnn.newBPTT(xs, ts, h0), nil, a)
+	var last float64
+	for i := 0; i < 50; i++ {
+		last = rnn.trainSequence(rnn.newBPTT(xs, ts, h0), nil, a)
+	}
+	if last >= first {
+		t.Fatalf("the loss should decrease: %v then %v", first, last)
+	}
+}
+
+func TestAccumulation(t *testing.T) {
+	rnd := rand.New(rand.NewSource(8))
+	rnn := network64(5, 5)
+	if rnn.config.AccumulationSteps != 1 {
+		t.Fatalf("expected no accumulation by default, got %v", rnn.config.AccumulationSteps)
+	}
+	randomize(rnn, rnd)
+	rnn.config.AccumulationSteps = 3
+	single := clone(t, rnn)
+	single.config.AccumulationSteps = 1
+	h0 := newMatrix[float64](2, rnn.config.HiddenNeurons)
+	var gs []*parameters[float64]
+	for i := 0; i < 3; i++ {
+		xs, ts := minibatch[float64](randomBatch(rnd, 2, 4, 5).Streams)
+		gs = append(gs, rnn.backPropagation(rnn.forwardPass(nil, xs, h0, nil), ts, 0))
+	}
+	// The average of the gradients
+	avg := newParameters[float64](rnn.config)
+	for _, g := range gs {
+		for i, dparam := range g.raw() {
+			for j, d := range dparam {
+				avg.raw()[i][j] += d / 3
+			}
+		}
+	}
+	opt := newOptimizer[float64](rnn.config)
+	whh := duplicate(rnn.whh)
+	for _, g := range gs[:2] {
+		opt.step(rnn, g)
+	}
+	if !equal(whh, rnn.whh, 0) {
+		t.Fatal("the parameters should not change before 3 accumulated gradients")
+	}
+	opt.step(rnn, gs[2])
+	newOptimizer[float64](single.config).step(single, avg)
+	if !equal(single.whh, rnn.whh, 1e-12) || !equal(single.wxh, rnn.wxh, 1e-12) {
+		t.Fatal("the update should use the average of the accumulated gradients")
+	}
+	if clone(t, rnn).config.AccumulationSteps != 3 {
+		t.Fatal("the accumulation count should be recorded in the checkpoint")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	rnd := rand.New(rand.NewSource(15))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	rnn.config.AccumulationSteps = 3
+	single := clone(t, rnn)
+	single.config.AccumulationSteps = 1
+	tsets := []TrainingSet{randomBatch(rnd, 2, 4, 5), randomBatch(rnd, 2, 4, 5)}
+	// The average of the gradients of the two TrainingSets
+	avg := newParameters[float64](rnn.config)
+	h0 := newMatrix[float64](2, rnn.config.HiddenNeurons)
+	for _, tset := range tsets {
+		xs, ts := minibatch[float64](tset.Streams)
+		for i, d := range rnn.backPropagation(rnn.forwardPass(nil, xs, h0, nil), ts, 0).raw() {
+			axpy(avg.raw()[i], 0.5, d)
+		}
+	}
+	newOptimizer[float64](single.config).step(single, avg)
+	// The training ends after 2 of the 3 accumulation steps
+	for i := range tsets {
+		for j := range tsets[i].Streams {
+			tsets[i].Streams[j].Reset = true
+		}
+	}
+	feed, info := rnn.train()
+	train(feed, info, tsets)
+	close(feed)
+	for range info {
+	}
+	if !equal(single.whh, rnn.whh, 1e-12) || !equal(single.why, rnn.why, 1e-12) {
+		t.Fatal("the last gradients should be averaged and applied at the end of the training")
+	}
+}
+
+// train feeds the TrainingSets one by one and returns the losses
+func train(feed chan<- TrainingSet, info <-chan float64, tsets []TrainingSet) []float64 {
+	var losses []float64
+	for _, tset := range tsets {
+		feed <- tset
+		losses = append(losses, <-info)
+	}
+	return losses
+}
+
+func TestTrainParallel(t *testing.T) {
+	rnd := rand.New(rand.NewSource(9))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	rnn.config.Seed = 1
+	rnn.config.RecurrentDropout = 0.2
+	var tsets []TrainingSet
+	for i := 0; i < 7; i++ {
+		tsets = append(tsets, randomBatch(rnd, 4, 4, 5))
+	}
+	// The training is deterministic given a seed
+	var trained []*network[float64]
+	for i := 0; i < 2; i++ {
+		c := clone(t, rnn)
+		feed, info := c.trainParallel(3)
+		train(feed, info, tsets)
+		close(feed)
+		trained = append(trained, c)
+	}
+	if !equal(trained[0].whh, trained[1].whh, 0) || !testEq(trained[0].by, trained[1].by) {
+		t.Fatal("the parallel training should be deterministic")
+	}
+	// A single worker trains like Train
+	single, parallel := clone(t, rnn), clone(t, rnn)
+	feed, info := single.train()
+	train(feed, info, tsets)
+	close(feed)
+	feed, info = parallel.trainParallel(1)
+	train(feed, info, tsets)
+	close(feed)
+	if !equal(single.whh, parallel.whh, 0) {
+		t.Fatal("a single worker should train like Train")
+	}
+	// The workers train like Train on the whole mini-batches: every stream is carried on
+	// from the state where the previous mini-batch left it, whatever the number of workers
+	rnn.config.RecurrentDropout = 0
+	together := clone(t, rnn)
+	feed, info = together.train()
+	l := train(feed, info, tsets)
+	close(feed)
+	for _, workers := range []int{2, 3, 4, 6} {
+		parallel := clone(t, rnn)
+		feed, info = parallel.trainParallel(workers)
+		lp := train(feed, info, tsets)
+		close(feed)
+		for i := range l {
+			if math.Abs(l[i]-lp[i]) > 1e-9 {
+				t.Fatalf("%v workers: the loss of the mini-batch %v is %v instead of %v", workers, i, lp[i], l[i])
+			}
+		}
+		if !equal(together.whh, parallel.whh, 1e-9) || !equal(together.why, parallel.why, 1e-9) {
+			t.Fatalf("%v workers should train like a mini-batch", workers)
+		}
+	}
+}
+
+func TestShare(t *testing.T) {
+	streams := make([]TrainingSet, 5)
+	for i, size := range []int{2, 2, 1} {
+		if n := len(share(streams, i, 3)); n != size {
+			t.Fatalf("worker %v: expected %v streams, got %v", i, size, n)
+		}
+	}
+	if len(share(streams[:1], 0, 2)) != 1 || len(share(streams[:1], 1, 2)) != 0 {
+		t.Fatal("a single stream should go to the first worker")
+	}
+}
+
+func TestTrainParallelChunks(t *testing.T) {
+	rnd := rand.New(rand.NewSource(12))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	// The parameters do not change, so the losses only depend on the hidden states
+	rnn.config.LearningRate = 0
+	seq := randomBatch(rnd, 1, 20, 5).Streams[0]
+	// The loss of the steps from lo to hi, from the hidden state h0
+	loss := func(lo, hi int, h0 *matrix[float64]) (float64, *matrix[float64]) {
+		xs, ts := minibatch[float64]([]TrainingSet{seq.slice(lo, hi)})
+		f := rnn.forwardPass(nil, xs, h0, nil)
+		return crossEntropy(f.ps, ts), f.hs[len(f.hs)-1]
+	}
+	zeros := func() *matrix[float64] { return newMatrix[float64](1, rnn.config.HiddenNeurons) }
+	// Three workers get the chunks [0, 4), [4, 7) and [7, 10) of the first sequence,
+	// then [10, 14), [14, 17) and [17, 20) of the second one
+	l0, _ := loss(0, 4, zeros())
+	l1, _ := loss(4, 7, zeros())
+	l2, h := loss(7, 10, zeros())
+	l3, _ := loss(10, 14, h)
+	l4, _ := loss(14, 17, zeros())
+	l5, _ := loss(17, 20, zeros())
+	first := seq.slice(0, 10)
+	first.Reset = true
+	feed, info := rnn.trainParallel(3)
+	losses := train(feed, info, []TrainingSet{first, seq.slice(10, 20)})
+	close(feed)
+	for range info {
+	}
+	for i, expected := range []float64{l0 + l1 + l2, l3 + l4 + l5} {
+		if math.Abs(losses[i]-expected) > 1e-12*expected {
+			t.Fatalf("sequence %v should be trained by chunks: expected a loss of %v, got %v", i, expected, losses[i])
+		}
+	}
+}
+
+func TestTrainHogwild(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the Hogwild updates race by design")
+	}
+	rnd := rand.New(rand.NewSource(10))
+	rnn := network64(5, 5)
+	tset := randomBatch(rnd, 2, 10, 5)
+	for i := range tset.Streams {
+		tset.Streams[i].Reset = true
+	}
+	xs, ts := minibatch[float64](tset.Streams)
+	h0 := newMatrix[float64](2, rnn.config.HiddenNeurons)
+	loss := func() float64 {
+		return crossEntropy(rnn.forwardPass(nil, xs, h0, nil).ps, ts)
+	}
+	before := loss()
+	feed, info := rnn.trainHogwild(4)
+	for i := 0; i < 200; i++ {
+		feed <- tset
+	}
+	close(feed)
+	for range info {
+	}
+	if after := loss(); after >= before {
+		t.Fatalf("the loss should decrease: %v then %v", before, after)
+	}
+}
+
+func TestTrainHogwildStates(t *testing.T) {
+	rnd := rand.New(rand.NewSource(11))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	// The parameters do not change, so the losses only depend on the hidden states
+	rnn.config.LearningRate = 0
+	// Contiguous windows of a single sequence, as sent by the codecs
+	seq := randomBatch(rnd, 1, 40, 5).Streams[0]
+	var tsets []TrainingSet
+	for i := 0; i < 40; i += 10 {
+		tsets = append(tsets, TrainingSet{Inputs: seq.Inputs[i : i+10], Targets: seq.Targets[i : i+10], Reset: i == 0})
+	}
+	feed, info := rnn.trainHogwild(3)
+	for round := 0; round < 5; round++ {
+		for i, loss := range train(feed, info, tsets) {
+			xs, ts := minibatch[float64](tsets[i].streams())
+			expected := crossEntropy(rnn.forwardPass(nil, xs, newMatrix[float64](1, rnn.config.HiddenNeurons), nil).ps, ts)
+			if math.Abs(loss-expected) > 1e-12*expected {
+				t.Fatalf("window %v should start from a zero hidden state: expected a loss of %v, got %v", i, expected, loss)
+			}
+		}
+	}
+	close(feed)
+	for range info {
+	}
+}
+
+// comparePrecisions compares the losses and the gradients computed in float32 to the float64 ones
+// on the same parameters; seed is the seed of the dropout masks (0 disables the dropout)
+func comparePrecisions(t *testing.T, rnn *network[float64], tset TrainingSet, seed int64) {
+	// The float64 network holds the float32 parameters exactly
+	rnn32 := rnn.convert(32).(*network[float32])
+	rnn64 := rnn32.convert(64).(*network[float64])
+	dropout := func() *rand.Rand {
+		if seed == 0 {
+			return nil
+		}
+		return rand.New(rand.NewSource(seed))
+	}
+	b := len(tset.Streams)
+	xs, ts := minibatch[float64](tset.Streams)
+	f := rnn64.forwardPass(nil, xs, newMatrix[float64](b, rnn.config.HiddenNeurons), dropout())
+	loss := crossEntropy(f.ps, ts)
+	grads := rnn64.backPropagation(f, ts, 0).raw()
+	xs32, ts32 := minibatch[float32](tset.Streams)
+	f32 := rnn32.forwardPass(nil, xs32, newMatrix[float32](b, rnn.config.HiddenNeurons), dropout())
+	loss32 := crossEntropy(f32.ps, ts32)
+	grads32 := rnn32.backPropagation(f32, ts32, 0).raw()
+	if math.Abs(loss-loss32) > 1e-5*loss {
+		t.Errorf("the float32 loss %v differs from the float64 loss %v", loss32, loss)
+	}
+	for k := range grads {
+		for i, g := range grads[k] {
+			if math.Abs(g-float64(grads32[k][i])) > 1e-5*math.Max(1, math.Abs(g)) {
+				t.Errorf("parameter %v[%v]: expected a gradient of %v, got %v", k, i, g, grads32[k][i])
+			}
+		}
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	rnd := rand.New(rand.NewSource(13))
+	rnn := network64(5, 5)
+	if rnn.config.Precision != 64 {
+		t.Fatalf("expected a 64 bits precision by default, got %v", rnn.config.Precision)
+	}
+	randomize(rnn, rnd)
+	tset := randomBatch(rnd, 3, 6, 5)
+	comparePrecisions(t, rnn, tset, 0)
+	comparePrecisions(t, rnn, sparseOf(tset), 0)
+	rnn.config.InputDropout = 0.3
+	rnn.config.RecurrentDropout = 0.3
+	comparePrecisions(t, rnn, tset, 42)
+
+	os.Setenv("RNN_EMBEDDINGSIZE", "3")
+	os.Setenv("RNN_LAYERNORM", "true")
+	os.Setenv("RNN_PRECISION", "32")
+	defer os.Unsetenv("RNN_EMBEDDINGSIZE")
+	defer os.Unsetenv("RNN_LAYERNORM")
+	defer os.Unsetenv("RNN_PRECISION")
+	r := NewRNN(5, 5)
+	if r.Precision() != 32 {
+		t.Fatalf("expected a 32 bits precision, got %v", r.Precision())
+	}
+	converted, err := r.Convert(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnn = converted.net.(*network[float64])
+	randomize(rnn, rnd)
+	comparePrecisions(t, rnn, tset, 0)
+	comparePrecisions(t, rnn, sparseOf(tset), 0)
+	if _, err := r.Convert(16); err == nil {
+		t.Fatal("16 bits is not a precision")
+	}
+
+	// The float32 training follows the float64 one
+	for i := range tset.Streams {
+		tset.Streams[i].Reset = true
+	}
+	rnn.config.Seed = 1
+	rnn32 := rnn.convert(32).(*network[float32])
+	rnn64 := rnn32.convert(64).(*network[float64])
+	feed, info := rnn64.train()
+	losses := train(feed, info, []TrainingSet{tset, tset, tset, tset, tset})
+	close(feed)
+	feed, info = rnn32.train()
+	losses32 := train(feed, info, []TrainingSet{tset, tset, tset, tset, tset})
+	close(feed)
+	for i := range losses {
+		if math.Abs(losses[i]-losses32[i]) > 1e-4*losses[i] {
+			t.Fatalf("the float32 losses %v differ from the float64 losses %v", losses32, losses)
+		}
+	}
+	if losses32[4] >= losses32[0] {
+		t.Fatalf("the loss should decrease: %v", losses32)
+	}
+}
+
+func TestPrecisionGob(t *testing.T) {
+	r64 := NewRNN(65, 65)
+	r32, err := r64.Convert(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64, err := r64.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b32, err := r32.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b32) > len(b64)*6/10 {
+		t.Fatalf("the float32 checkpoint should be about half the size: %v and %v bytes", len(b32), len(b64))
+	}
+	var restored RNN
+	if err := restored.GobDecode(b32); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Precision() != 32 {
+		t.Fatalf("the precision should be recorded in the checkpoint, got %v", restored.Precision())
+	}
+	rnn, rnn32 := restored.net.(*network[float32]), r32.net.(*network[float32])
+	for k, param := range rnn.params().raw() {
+		for i, v := range param {
+			if v != rnn32.params().at(k)[i] {
+				t.Fatalf("parameter %v[%v] differs", k, i)
+			}
+		}
+	}
+
+	// The checkpoints that do not record the precision are in float64
+	b := r64.net.backup()
+	b.Config.Precision = 0
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.GobDecode(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Precision() != 64 {
+		t.Fatalf("expected a 64 bits precision, got %v", restored.Precision())
+	}
+}
+
+// benchmarkTrain feeds b.N TrainingSets of a char-rnn sized network to the training
+func benchmarkTrain(b *testing.B, train func(*RNN) (chan<- TrainingSet, <-chan float64)) {
+	rnd := rand.New(rand.NewSource(11))
+	rnn := NewRNN(65, 65)
+	tsets := make([]TrainingSet, 16)
+	for i := range tsets {
+		tsets[i] = sparseOf(randomBatch(rnd, 1, 25, 65)).Streams[0]
+	}
+	feed, info := train(rnn)
+	b.ReportAllocs()
+	done := make(chan struct{})
+	go func() {
+		for range info {
+		}
+		close(done)
+	}()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		feed <- tsets[i%len(tsets)]
+	}
+	close(feed)
+	<-done
+}
+
+func BenchmarkTrain(b *testing.B) {
+	benchmarkTrain(b, (*RNN).Train)
+}
+
+func BenchmarkTrainFloat32(b *testing.B) {
+	benchmarkTrain(b, func(rnn *RNN) (chan<- TrainingSet, <-chan float64) {
+		rnn, err := rnn.Convert(32)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return rnn.Train()
+	})
+}
+
+func BenchmarkTrainHogwild(b *testing.B) {
+	if raceEnabled {
+		b.Skip("the Hogwild updates race by design")
+	}
+	benchmarkTrain(b, func(rnn *RNN) (chan<- TrainingSet, <-chan float64) {
+		return rnn.TrainHogwild(runtime.NumCPU())
+	})
+}
+
+// BenchmarkPass measures a forward and backward pass of a training step
+func BenchmarkPass(b *testing.B) {
+	rnd := rand.New(rand.NewSource(12))
+	rnn := network64(65, 65)
+	xs, ts := minibatch[float64](sparseOf(randomBatch(rnd, 4, 25, 65)).Streams)
+	h0 := newMatrix[float64](4, rnn.config.HiddenNeurons)
+	ws := &workspace[float64]{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rnn.backPropagation(rnn.forwardPass(ws, xs, h0, rnd), ts, 0)
+	}
+}
+
+func TestPredictBuffer(t *testing.T) {
+	rnd := rand.New(rand.NewSource(14))
+	rnn := network64(5, 5)
+	randomize(rnn, rnd)
+	prime := [][]float64{{0, 1, 0, 0, 0}, {0, 0, 0, 1, 0}}
+	// The distributions are fed back as they are
+	ys := rnn.predict(prime, 3, func(p []float64) []float64 { return p })
+	c := rnn.newCell()
+	rnn.step(c, prime[0])
+	y := append([]float64(nil), rnn.step(c, prime[1])...)
+	y[3] = 1
+	for i := range ys {
+		expected := rnn.step(c, y)
+		if !testEq(ys[i], expected) {
+			t.Fatalf("step %v: expected %v, got %v", i, expected, ys[i])
+		}
+		y = append([]float64(nil), expected...)
+	}
+}
+
+// BenchmarkPredict measures the generation of 100 elements
+func BenchmarkPredict(b *testing.B) {
+	rnn := NewRNN(65, 65)
+	prime := make([][]float64, 1)
+	prime[0] = make([]float64, 65)
+	prime[0][0] = 1
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rnn.Predict(prime, 100, func(p []float64) []float64 { return p })
+	}
+}
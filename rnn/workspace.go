@@ -0,0 +1,98 @@
+package rnn
+
+import (
+	"math/rand"
+)
+
+// workspace holds the matrices and the vectors of the forward and backward passes.
+// They are handed out in the same order at every pass, so that they are allocated
+// by the first pass only and reused by the next ones
+type workspace[T float] struct {
+	matrices []*matrix[T]
+	vectors  [][]T
+	m, v     int // number of matrices and vectors in use
+	pass     pass[T]
+	grads    *parameters[T]
+}
+
+// reset makes all the matrices and the vectors of the workspace available again
+func (ws *workspace[T]) reset() {
+	ws.m, ws.v = 0, 0
+}
+
+// matrix returns a zero matrix of r rows and c columns
+func (ws *workspace[T]) matrix(r, c int) *matrix[T] {
+	if ws.m == len(ws.matrices) {
+		ws.matrices = append(ws.matrices, nil)
+	}
+	m := ws.matrices[ws.m]
+	if m == nil || m.rows != r || m.cols != c {
+		m = newMatrix[T](r, c)
+		ws.matrices[ws.m] = m
+	} else {
+		zero(m.data)
+	}
+	ws.m++
+	return m
+}
+
+// vector returns a zero vector of n elements
+func (ws *workspace[T]) vector(n int) []T {
+	if ws.v == len(ws.vectors) {
+		ws.vectors = append(ws.vectors, nil)
+	}
+	v := ws.vectors[ws.v]
+	if cap(v) < n {
+		v = make([]T, n)
+	}
+	v = v[:n]
+	zero(v)
+	ws.vectors[ws.v] = v
+	ws.v++
+	return v
+}
+
+// gradients returns zero parameters shaped by the configuration
+func (ws *workspace[T]) gradients(c neuralNetConfig) *parameters[T] {
+	if ws.grads == nil || !ws.grads.fits(c) {
+		ws.grads = newParameters[T](c)
+	} else {
+		ws.grads.zero()
+	}
+	return ws.grads
+}
+
+// dropoutMask returns a mask that drops the units with the probability p and
+// scales the others by 1/(1-p) (inverted dropout), so that nothing has to be
+// rescaled when the network is used without dropout.
+// It returns nil if p is zero
+func (ws *workspace[T]) dropoutMask(rnd *rand.Rand, rows, cols int, p float64) *matrix[T] {
+	if p <= 0 {
+		return nil
+	}
+	mask := ws.matrix(rows, cols)
+	for i := range mask.data {
+		if rnd.Float64() >= p {
+			mask.data[i] = T(1 / (1 - p))
+		}
+	}
+	return mask
+}
+
+// dropped returns m with the mask applied, or m itself if the mask is nil
+func (ws *workspace[T]) dropped(m, mask *matrix[T]) *matrix[T] {
+	if mask == nil {
+		return m
+	}
+	d := ws.matrix(m.dims())
+	copy(d.data, m.data)
+	mulElem(d.data, mask.data)
+	return d
+}
+
+// zero sets all the elements of v to zero
+func zero[T float](v []T) {
+	for i := range v {
+		v[i] = 0
+	}
+}